@@ -0,0 +1,82 @@
+package sqlxcluster
+
+import (
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var pkgPath = reflect.TypeOf(queryLog{}).PkgPath()
+
+// callerSkip lists the prefixes of the functions never reported as callers.
+var callerSkip = []string{
+	pkgPath + ".",
+	pkgPath + "/",
+	"database/sql.",
+	"github.com/jmoiron/sqlx.",
+}
+
+type Caller struct {
+	File     string
+	Line     int
+	Function string
+}
+
+func (c Caller) String() string {
+	if c.File == "" {
+		return ""
+	}
+	return c.File + ":" + strconv.Itoa(c.Line)
+}
+
+// findCaller returns the first frame outside of the database code and skip.
+func findCaller(skip []string) Caller {
+	var pcs [32]uintptr
+	n := runtime.Callers(3, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !skipFrame(frame, skip) {
+			return Caller{File: frame.File, Line: frame.Line, Function: frame.Function}
+		}
+		if !more {
+			return Caller{}
+		}
+	}
+}
+
+func skipFrame(frame runtime.Frame, skip []string) bool {
+	for _, prefix := range skip {
+		if strings.HasPrefix(frame.Function, prefix) {
+			return true
+		}
+	}
+	for _, prefix := range callerSkip {
+		if strings.HasPrefix(frame.Function, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// findStack returns the stack from the frame findCaller would return.
+func findStack(skip []string) []Caller {
+	var pcs [64]uintptr
+	n := runtime.Callers(3, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	var stack []Caller
+	for {
+		frame, more := frames.Next()
+		if len(stack) > 0 || !skipFrame(frame, skip) {
+			if frame.Function == "runtime.goexit" {
+				break
+			}
+			stack = append(stack, Caller{File: frame.File, Line: frame.Line, Function: frame.Function})
+		}
+		if !more {
+			break
+		}
+	}
+	return stack
+}
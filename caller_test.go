@@ -0,0 +1,59 @@
+package sqlxcluster_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-comm/sqlxcluster"
+	"github.com/go-comm/sqlxcluster/internal/fakedriver"
+)
+
+func repoInsert(db sqlxcluster.DB) {
+	db.Exec("insert into t values (1)")
+}
+
+func TestLoggedCaller(t *testing.T) {
+	d := fakedriver.New()
+	var events []*sqlxcluster.QueryEvent
+	c := sqlxcluster.OpenClusterDB(d.Name, d.Connector("primary"), nil, sqlxcluster.WithEnableLog(true),
+		sqlxcluster.WithCallerSkip("github.com/go-comm/sqlxcluster_test.repo"),
+		sqlxcluster.WithLogger(sqlxcluster.QueryLoggerFunc(func(ctx context.Context, e *sqlxcluster.QueryEvent) {
+			events = append(events, e)
+		})))
+	defer c.Close()
+
+	repoInsert(c)
+	tx, err := sqlxcluster.Begin(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Exec("update t set a = 1")
+	tx.Commit()
+	rows, err := c.Queryx("select 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	if len(events) != 5 {
+		t.Fatalf("expected 5 events, got %d", len(events))
+	}
+	for _, e := range events {
+		if !strings.HasSuffix(e.Caller.File, "caller_test.go") || !strings.HasSuffix(e.Caller.Function, ".TestLoggedCaller") {
+			t.Errorf("unexpected caller %s %s", e.Caller, e.Caller.Function)
+		}
+	}
+}
+
+func TestCallerTag(t *testing.T) {
+	d := fakedriver.New()
+	c := sqlxcluster.OpenClusterDB(d.Name, d.Connector("primary"), nil, sqlxcluster.WithSQLComment(sqlxcluster.CallerTag("func")))
+	defer c.Close()
+
+	c.Exec("update t set a = 1")
+	want := "update t set a = 1 /*func='github.com%2Fgo-comm%2Fsqlxcluster_test.TestCallerTag'*/"
+	if execs := d.Execs(); len(execs) != 1 || execs[0] != want {
+		t.Fatalf("unexpected tagged statement %q", execs)
+	}
+}
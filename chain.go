@@ -0,0 +1,651 @@
+package sqlxcluster
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	_ DB          = (*chainDB)(nil)
+	_ Tx          = (*chainTx)(nil)
+	_ Interceptor = InterceptorFuncs{}
+	_ Interceptor = (*queryLog)(nil)
+)
+
+// Interceptor runs around the statements of a DB or Tx wrapped by Chain.
+//
+// Before may rewrite e.Query, e.Args and, for named statements, e.Arg, or
+// return an error to fail the statement without running it, which is the
+// only way to short-circuit a statement: an interceptor cannot supply a
+// result of its own. Rows from QueryRow and QueryRowx then fail with that
+// error on Scan. The context it returns is passed down to the statement and
+// to After. After is called once for every Before that succeeded, in reverse
+// order, and sees the outcome in e.Err, e.Result and e.Duration. For result
+// sets After runs when the rows are closed, with the number of rows read in
+// e.Rows.
+//
+// The executions of the statements prepared on the DB or Tx go through the
+// interceptors too, as do the statements of the connections taken with Conn
+// or Connx and their transactions. Prepared statements cannot be rewritten.
+type Interceptor interface {
+	Before(ctx context.Context, e *QueryEvent) (context.Context, error)
+	After(ctx context.Context, e *QueryEvent)
+}
+
+// InterceptorFuncs is an Interceptor made of optional functions.
+type InterceptorFuncs struct {
+	BeforeFunc func(ctx context.Context, e *QueryEvent) (context.Context, error)
+	AfterFunc  func(ctx context.Context, e *QueryEvent)
+}
+
+func (f InterceptorFuncs) Before(ctx context.Context, e *QueryEvent) (context.Context, error) {
+	if f.BeforeFunc == nil {
+		return ctx, nil
+	}
+	return f.BeforeFunc(ctx, e)
+}
+
+func (f InterceptorFuncs) After(ctx context.Context, e *QueryEvent) {
+	if f.AfterFunc != nil {
+		f.AfterFunc(ctx, e)
+	}
+}
+
+// Chain runs interceptors around the statements of db and of the transactions
+// begun from it with Begin or BeginTx. Before hooks run in the given order and
+// After hooks in reverse order.
+func Chain(db DB, interceptors ...Interceptor) DB {
+	return newChainDB(db, newChain(&options{interceptors: interceptors, driverName: db.DriverName()}, nil))
+}
+
+// ChainTx runs interceptors around the statements of tx, its commit and its
+// rollback.
+func ChainTx(tx Tx, interceptors ...Interceptor) Tx {
+	return newChainTx(tx, newChain(&options{interceptors: interceptors, driverName: tx.DriverName()}, nil))
+}
+
+// chain holds the interceptors of one node, the query log first.
+type chain struct {
+	interceptors []Interceptor
+	cluster      atomic.Value // string
+	node         string
+	role         Role
+	driver       string
+	skip         []string
+	log          *queryLog // first of the interceptors, if any
+}
+
+func newChain(os *options, l *queryLog) *chain {
+	c := &chain{
+		node:   os.nodeName,
+		role:   os.nodeRole,
+		driver: os.driverName,
+		skip:   os.callerSkip,
+	}
+	c.setCluster(os.name)
+	if l != nil {
+		c.log = l
+		c.interceptors = append(c.interceptors, l)
+	}
+	c.interceptors = append(c.interceptors, os.interceptors...)
+	if os.explainer != nil {
+		c.interceptors = append(c.interceptors, os.explainer)
+	}
+	if len(os.commentTags) > 0 {
+		c.interceptors = append(c.interceptors, newCommenter(os.commentTags, os.callerSkip, os.driverName))
+	}
+	return c
+}
+
+func (c *chain) setCluster(name string) {
+	c.cluster.Store(name)
+}
+
+// idle reports whether the statements can run as if the chain was not there.
+func (c *chain) idle() bool {
+	switch len(c.interceptors) {
+	case 0:
+		return true
+	case 1:
+		return c.log != nil && !c.log.Logged()
+	}
+	return false
+}
+
+func (c *chain) event(op Op, query string, args []interface{}) *QueryEvent {
+	return &QueryEvent{
+		Time:         time.Now(),
+		Op:           op,
+		Query:        query,
+		Args:         args,
+		RowsAffected: -1,
+		LastInsertID: -1,
+		Rows:         -1,
+		Cluster:      c.cluster.Load().(string),
+		Node:         c.node,
+		Role:         c.role,
+		Driver:       c.driver,
+	}
+}
+
+func (c *chain) before(ctx context.Context, e *QueryEvent) (context.Context, int, error) {
+	for i, interceptor := range c.interceptors {
+		next, err := interceptor.Before(ctx, e)
+		if err != nil {
+			return ctx, i, err
+		}
+		if next != nil {
+			ctx = next
+		}
+	}
+	return ctx, len(c.interceptors), nil
+}
+
+func (c *chain) after(ctx context.Context, e *QueryEvent, n int) {
+	if e.Duration == 0 {
+		e.Duration = time.Since(e.Time)
+	}
+	setResult(e, e.Result)
+	for i := n - 1; i >= 0; i-- {
+		c.interceptors[i].After(ctx, e)
+	}
+}
+
+// run calls f between the Before and After hooks.
+func (c *chain) run(ctx context.Context, e *QueryEvent, f func(ctx context.Context) error) error {
+	if c.idle() {
+		return f(ctx)
+	}
+	ctx, n, err := c.before(ctx, e)
+	if err == nil {
+		err = f(ctx)
+	}
+	e.Err = err
+	c.after(ctx, e, n)
+	return err
+}
+
+// errConnector fails every connection with err.
+type errConnector struct {
+	err error
+}
+
+func (c errConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return nil, c.err
+}
+
+func (c errConnector) Driver() driver.Driver {
+	return nil
+}
+
+// failedRow returns a row failing with err.
+func failedRow(err error) *sql.Row {
+	db := sql.OpenDB(errConnector{err})
+	defer db.Close()
+	return db.QueryRow("")
+}
+
+// failedRowx is failedRow for sqlx.
+func failedRowx(err error) *sqlx.Row {
+	db := sqlx.NewDb(sql.OpenDB(errConnector{err}), "")
+	defer db.Close()
+	return db.QueryRowx("")
+}
+
+// hasContext reports whether the methods of c without a context call the others.
+func hasContext(c Command) bool {
+	switch c.(type) {
+	case *wrappedDB, *wrappedTx:
+		return true
+	}
+	return false
+}
+
+// command runs the statements of a DB or a Tx through a chain.
+type command struct {
+	*chain
+	c  Command
+	tl *txLog // nil outside of a transaction
+}
+
+func (c *command) event(op Op, query string, args []interface{}) *QueryEvent {
+	e := c.chain.event(op, query, args)
+	if c.tl != nil {
+		e.TxID = c.tl.id
+		atomic.AddInt64(&c.tl.statements, 1)
+	}
+	return e
+}
+
+func (c *command) namedEvent(op Op, query string, arg interface{}) *QueryEvent {
+	e := c.event(op, query, nil)
+	e.Arg = arg
+	return e
+}
+
+// stmt runs the executions of s through the chain.
+func (c *command) stmt(s *Stmt, query string, err error) (*Stmt, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &Stmt{Stmt: s.Stmt, c: c, query: query, next: s}, nil
+}
+
+func (c *command) namedStmt(s *NamedStmt, query string, err error) (*NamedStmt, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &NamedStmt{NamedStmt: s.NamedStmt, c: c, query: query, next: s}, nil
+}
+
+func (c *command) Exec(query string, args ...interface{}) (d sql.Result, err error) {
+	e := c.event(OpExec, query, args)
+	err = c.run(context.Background(), e, func(ctx context.Context) error {
+		if hasContext(c.c) {
+			d, err = c.c.ExecContext(ctx, tagged(ctx, e.Query), e.Args...)
+		} else {
+			d, err = c.c.Exec(tagged(ctx, e.Query), e.Args...)
+		}
+		e.Result = d
+		return err
+	})
+	return
+}
+
+func (c *command) ExecContext(ctx context.Context, query string, args ...interface{}) (d sql.Result, err error) {
+	e := c.event(OpExec, query, args)
+	err = c.run(ctx, e, func(ctx context.Context) error {
+		d, err = c.c.ExecContext(ctx, tagged(ctx, e.Query), e.Args...)
+		e.Result = d
+		return err
+	})
+	return
+}
+
+func (c *command) Prepare(query string) (d *Stmt, err error) {
+	e := c.event(OpPrepare, query, nil)
+	err = c.run(context.Background(), e, func(ctx context.Context) error {
+		if hasContext(c.c) {
+			d, err = c.c.PrepareContext(ctx, e.Query)
+		} else {
+			d, err = c.c.Prepare(e.Query)
+		}
+		return err
+	})
+	return c.stmt(d, query, err)
+}
+
+func (c *command) PrepareContext(ctx context.Context, query string) (d *Stmt, err error) {
+	e := c.event(OpPrepare, query, nil)
+	err = c.run(ctx, e, func(ctx context.Context) error {
+		d, err = c.c.PrepareContext(ctx, e.Query)
+		return err
+	})
+	return c.stmt(d, query, err)
+}
+
+func (c *command) Query(query string, args ...interface{}) (*Rows, error) {
+	e := c.event(OpQuery, query, args)
+	return c.rows(context.Background(), e, func(ctx context.Context) (*Rows, error) {
+		if hasContext(c.c) {
+			return c.c.QueryContext(ctx, tagged(ctx, e.Query), e.Args...)
+		}
+		return c.c.Query(tagged(ctx, e.Query), e.Args...)
+	})
+}
+
+func (c *command) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	e := c.event(OpQuery, query, args)
+	return c.rows(ctx, e, func(ctx context.Context) (*Rows, error) {
+		return c.c.QueryContext(ctx, tagged(ctx, e.Query), e.Args...)
+	})
+}
+
+func (c *command) QueryRow(query string, args ...interface{}) (d *sql.Row) {
+	e := c.event(OpQueryRow, query, args)
+	err := c.run(context.Background(), e, func(ctx context.Context) error {
+		if hasContext(c.c) {
+			d = c.c.QueryRowContext(ctx, tagged(ctx, e.Query), e.Args...)
+		} else {
+			d = c.c.QueryRow(tagged(ctx, e.Query), e.Args...)
+		}
+		return d.Err()
+	})
+	if d == nil {
+		d = failedRow(err)
+	}
+	return
+}
+
+func (c *command) QueryRowContext(ctx context.Context, query string, args ...interface{}) (d *sql.Row) {
+	e := c.event(OpQueryRow, query, args)
+	err := c.run(ctx, e, func(ctx context.Context) error {
+		d = c.c.QueryRowContext(ctx, tagged(ctx, e.Query), e.Args...)
+		return d.Err()
+	})
+	if d == nil {
+		d = failedRow(err)
+	}
+	return
+}
+
+func (c *command) Get(dest interface{}, query string, args ...interface{}) (err error) {
+	e := c.event(OpGet, query, args)
+	return c.run(context.Background(), e, func(ctx context.Context) error {
+		if hasContext(c.c) {
+			return c.c.GetContext(ctx, dest, tagged(ctx, e.Query), e.Args...)
+		}
+		return c.c.Get(dest, tagged(ctx, e.Query), e.Args...)
+	})
+}
+
+func (c *command) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) (err error) {
+	e := c.event(OpGet, query, args)
+	return c.run(ctx, e, func(ctx context.Context) error {
+		return c.c.GetContext(ctx, dest, tagged(ctx, e.Query), e.Args...)
+	})
+}
+
+func (c *command) NamedExec(query string, arg interface{}) (d sql.Result, err error) {
+	e := c.namedEvent(OpNamedExec, query, arg)
+	err = c.run(context.Background(), e, func(ctx context.Context) error {
+		if hasContext(c.c) {
+			d, err = c.c.NamedExecContext(ctx, tagged(ctx, e.Query), e.Arg)
+		} else {
+			d, err = c.c.NamedExec(tagged(ctx, e.Query), e.Arg)
+		}
+		e.Result = d
+		return err
+	})
+	return
+}
+
+func (c *command) NamedExecContext(ctx context.Context, query string, arg interface{}) (d sql.Result, err error) {
+	e := c.namedEvent(OpNamedExec, query, arg)
+	err = c.run(ctx, e, func(ctx context.Context) error {
+		d, err = c.c.NamedExecContext(ctx, tagged(ctx, e.Query), e.Arg)
+		e.Result = d
+		return err
+	})
+	return
+}
+
+func (c *command) NamedQuery(query string, arg interface{}) (*Rows, error) {
+	e := c.namedEvent(OpNamedQuery, query, arg)
+	return c.rows(context.Background(), e, func(ctx context.Context) (*Rows, error) {
+		return c.c.NamedQuery(tagged(ctx, e.Query), e.Arg)
+	})
+}
+
+func (c *command) PrepareNamed(query string) (d *NamedStmt, err error) {
+	e := c.event(OpPrepareNamed, query, nil)
+	err = c.run(context.Background(), e, func(ctx context.Context) error {
+		if hasContext(c.c) {
+			d, err = c.c.PrepareNamedContext(ctx, e.Query)
+		} else {
+			d, err = c.c.PrepareNamed(e.Query)
+		}
+		return err
+	})
+	return c.namedStmt(d, query, err)
+}
+
+func (c *command) PrepareNamedContext(ctx context.Context, query string) (d *NamedStmt, err error) {
+	e := c.event(OpPrepareNamed, query, nil)
+	err = c.run(ctx, e, func(ctx context.Context) error {
+		d, err = c.c.PrepareNamedContext(ctx, e.Query)
+		return err
+	})
+	return c.namedStmt(d, query, err)
+}
+
+func (c *command) Preparex(query string) (d *Stmt, err error) {
+	e := c.event(OpPrepare, query, nil)
+	err = c.run(context.Background(), e, func(ctx context.Context) error {
+		if hasContext(c.c) {
+			d, err = c.c.PreparexContext(ctx, e.Query)
+		} else {
+			d, err = c.c.Preparex(e.Query)
+		}
+		return err
+	})
+	return c.stmt(d, query, err)
+}
+
+func (c *command) PreparexContext(ctx context.Context, query string) (d *Stmt, err error) {
+	e := c.event(OpPrepare, query, nil)
+	err = c.run(ctx, e, func(ctx context.Context) error {
+		d, err = c.c.PreparexContext(ctx, e.Query)
+		return err
+	})
+	return c.stmt(d, query, err)
+}
+
+func (c *command) QueryRowx(query string, args ...interface{}) (d *sqlx.Row) {
+	e := c.event(OpQueryRow, query, args)
+	err := c.run(context.Background(), e, func(ctx context.Context) error {
+		if hasContext(c.c) {
+			d = c.c.QueryRowxContext(ctx, tagged(ctx, e.Query), e.Args...)
+		} else {
+			d = c.c.QueryRowx(tagged(ctx, e.Query), e.Args...)
+		}
+		return d.Err()
+	})
+	if d == nil {
+		d = failedRowx(err)
+	}
+	return
+}
+
+func (c *command) QueryRowxContext(ctx context.Context, query string, args ...interface{}) (d *sqlx.Row) {
+	e := c.event(OpQueryRow, query, args)
+	err := c.run(ctx, e, func(ctx context.Context) error {
+		d = c.c.QueryRowxContext(ctx, tagged(ctx, e.Query), e.Args...)
+		return d.Err()
+	})
+	if d == nil {
+		d = failedRowx(err)
+	}
+	return
+}
+
+func (c *command) Queryx(query string, args ...interface{}) (*Rows, error) {
+	e := c.event(OpQuery, query, args)
+	return c.rows(context.Background(), e, func(ctx context.Context) (*Rows, error) {
+		if hasContext(c.c) {
+			return c.c.QueryxContext(ctx, tagged(ctx, e.Query), e.Args...)
+		}
+		return c.c.Queryx(tagged(ctx, e.Query), e.Args...)
+	})
+}
+
+func (c *command) QueryxContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	e := c.event(OpQuery, query, args)
+	return c.rows(ctx, e, func(ctx context.Context) (*Rows, error) {
+		return c.c.QueryxContext(ctx, tagged(ctx, e.Query), e.Args...)
+	})
+}
+
+func (c *command) Select(dest interface{}, query string, args ...interface{}) (err error) {
+	e := c.event(OpSelect, query, args)
+	return c.run(context.Background(), e, func(ctx context.Context) error {
+		if hasContext(c.c) {
+			return c.c.SelectContext(ctx, dest, tagged(ctx, e.Query), e.Args...)
+		}
+		return c.c.Select(dest, tagged(ctx, e.Query), e.Args...)
+	})
+}
+
+func (c *command) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) (err error) {
+	e := c.event(OpSelect, query, args)
+	return c.run(ctx, e, func(ctx context.Context) error {
+		return c.c.SelectContext(ctx, dest, tagged(ctx, e.Query), e.Args...)
+	})
+}
+
+type chainDB struct {
+	*command
+	db DB
+}
+
+func newChainDB(db DB, c *chain) *chainDB {
+	return &chainDB{command: &command{chain: c, c: db}, db: db}
+}
+
+func (db *chainDB) Unwrap() DB {
+	return db.db
+}
+
+func (db *chainDB) nodeChain() *chain {
+	return db.chain
+}
+
+func (db *chainDB) Driver() driver.Driver {
+	return db.db.Driver()
+}
+
+func (db *chainDB) Conn(ctx context.Context) (*Conn, error) {
+	return db.Connx(ctx)
+}
+
+func (db *chainDB) Ping() error {
+	return db.db.Ping()
+}
+
+func (db *chainDB) PingContext(ctx context.Context) error {
+	return db.db.PingContext(ctx)
+}
+
+func (db *chainDB) Close() error {
+	return db.db.Close()
+}
+
+func (db *chainDB) Begin() (Tx, error) {
+	return db.BeginTxx(context.Background(), nil)
+}
+
+func (db *chainDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	return db.BeginTxx(ctx, opts)
+}
+
+func (db *chainDB) SetConnMaxIdleTime(d time.Duration) {
+	db.db.SetConnMaxIdleTime(d)
+}
+
+func (db *chainDB) SetConnMaxLifetime(d time.Duration) {
+	db.db.SetConnMaxLifetime(d)
+}
+
+func (db *chainDB) SetMaxIdleConns(n int) {
+	db.db.SetMaxIdleConns(n)
+}
+
+func (db *chainDB) SetMaxOpenConns(n int) {
+	db.db.SetMaxOpenConns(n)
+}
+
+func (db *chainDB) Stats() sql.DBStats {
+	return db.db.Stats()
+}
+
+func (db *chainDB) DriverName() string {
+	return db.db.DriverName()
+}
+
+func (db *chainDB) Connx(ctx context.Context) (*Conn, error) {
+	conn, err := db.db.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn.Conn, c: db.chain, next: conn}, nil
+}
+
+func (db *chainDB) Beginx() (Tx, error) {
+	return db.BeginTxx(context.Background(), nil)
+}
+
+func (db *chainDB) BeginTxx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	tx, err := db.beginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+func (db *chainDB) beginTx(ctx context.Context, opts *sql.TxOptions) (*chainTx, error) {
+	return db.chain.begin(ctx, func(ctx context.Context) (Tx, error) {
+		return db.db.BeginTxx(ctx, opts)
+	})
+}
+
+// begin reports the transaction begun by f.
+func (c *chain) begin(ctx context.Context, f func(ctx context.Context) (Tx, error)) (*chainTx, error) {
+	tl := newTxLog()
+	e := c.event(OpBegin, "BEGIN", nil)
+	e.TxID = tl.id
+	var tx Tx
+	err := c.run(ctx, e, func(ctx context.Context) (err error) {
+		tx, err = f(ctx)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &chainTx{command: &command{chain: c, c: tx, tl: tl}, tx: tx}, nil
+}
+
+type chainTx struct {
+	*command
+	tx Tx
+}
+
+// newChainTx wraps a transaction that has already begun.
+func newChainTx(tx Tx, c *chain) *chainTx {
+	tl := newTxLog()
+	e := c.event(OpBegin, "BEGIN", nil)
+	e.TxID = tl.id
+	c.run(context.Background(), e, func(ctx context.Context) error {
+		return nil
+	})
+	return &chainTx{command: &command{chain: c, c: tx, tl: tl}, tx: tx}
+}
+
+func (tx *chainTx) Unwrap() Tx {
+	return tx.tx
+}
+
+func (tx *chainTx) DriverName() string {
+	return tx.tx.DriverName()
+}
+
+func (tx *chainTx) Commit() error {
+	return tx.end(OpCommit, tx.tx.Commit)
+}
+
+func (tx *chainTx) Rollback() error {
+	// A Rollback deferred after a successful Commit is not worth an event.
+	if atomic.LoadInt32(&tx.tl.ended) == 1 {
+		return tx.tx.Rollback()
+	}
+	return tx.end(OpRollback, tx.tx.Rollback)
+}
+
+func (tx *chainTx) end(op Op, f func() error) error {
+	e := tx.chain.event(op, strings.ToUpper(string(op)), nil)
+	e.TxID = tx.tl.id
+	e.Statements = atomic.LoadInt64(&tx.tl.statements)
+	return tx.run(context.Background(), e, func(ctx context.Context) error {
+		err := f()
+		if err == nil {
+			atomic.StoreInt32(&tx.tl.ended, 1)
+		}
+		e.TxDuration = time.Since(tx.tl.begin)
+		return err
+	})
+}
@@ -0,0 +1,115 @@
+package sqlxcluster
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-comm/sqlxcluster/internal/fakedriver"
+)
+
+func TestChain(t *testing.T) {
+	d := fakedriver.New()
+	c := OpenClusterDB(d.Name, d.Connector("primary"), nil)
+	defer c.Close()
+
+	var calls []string
+	denied := errors.New("denied")
+	trace := func(name string) Interceptor {
+		return InterceptorFuncs{
+			BeforeFunc: func(ctx context.Context, e *QueryEvent) (context.Context, error) {
+				calls = append(calls, name+" before "+e.Query)
+				return ctx, nil
+			},
+			AfterFunc: func(ctx context.Context, e *QueryEvent) {
+				calls = append(calls, name+" after "+e.Query)
+			},
+		}
+	}
+	rewrite := InterceptorFuncs{
+		BeforeFunc: func(ctx context.Context, e *QueryEvent) (context.Context, error) {
+			if strings.HasPrefix(e.Query, "delete") {
+				return ctx, denied
+			}
+			e.Query += " /* app */"
+			return ctx, nil
+		},
+	}
+	db := Chain(c, trace("a"), rewrite, trace("b"))
+
+	if _, err := db.Exec("update t set a = 1"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a before update t set a = 1", "b before update t set a = 1 /* app */",
+		"b after update t set a = 1 /* app */", "a after update t set a = 1 /* app */"}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected calls %q", calls)
+	}
+	if execs := d.Execs(); len(execs) != 1 || execs[0] != "update t set a = 1 /* app */" {
+		t.Fatalf("unexpected execs %q", execs)
+	}
+
+	calls = nil
+	if _, err := db.Exec("delete from t"); err != denied {
+		t.Fatalf("expected the interceptor error, got %v", err)
+	}
+	if len(calls) != 2 || calls[1] != "a after delete from t" || len(d.Execs()) != 1 {
+		t.Fatalf("expected the statement to be short-circuited, got %q", calls)
+	}
+	var n int
+	if err := db.QueryRow("delete from t returning 1").Scan(&n); err != denied {
+		t.Fatalf("expected the interceptor error from the row, got %v", err)
+	}
+	if err := db.QueryRowxContext(context.Background(), "delete from t returning 1").Scan(&n); err != denied {
+		t.Fatalf("expected the interceptor error from the row, got %v", err)
+	}
+
+	calls = nil
+	tx, err := Begin(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Exec("update t set b = 2")
+	tx.Commit()
+	if len(calls) != 6*2 {
+		t.Fatalf("expected begin, exec and commit to be intercepted, got %q", calls)
+	}
+}
+
+func TestInterceptorsOption(t *testing.T) {
+	d := fakedriver.New()
+	var events []*QueryEvent
+	c := OpenClusterDB(d.Name, d.Connector("primary"), nil, WithInterceptors(InterceptorFuncs{
+		AfterFunc: func(ctx context.Context, e *QueryEvent) {
+			events = append(events, e)
+		},
+	}))
+	defer c.Close()
+
+	stmt, err := c.Preparex("update t set a = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt.Exec(1)
+	stmt.Close()
+	rows, err := c.Queryx("select 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+	if len(events) != 3 || events[1].Op != OpExec || events[1].RowsAffected != 1 || events[2].Rows != 2 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	// Logging runs before the interceptors and keeps them when toggled.
+	c.SetLog(true, false, func(b []byte) (int, error) { return len(b), nil })
+	c.Exec("update t set a = 2")
+	c.SetLog(false, false, nil)
+	c.Exec("update t set a = 3")
+	if len(events) != 5 || events[4].Query != "update t set a = 3" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+}
@@ -3,59 +3,86 @@ package sqlxcluster
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"math/rand"
-	"os"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
-var rn = rand.New(rand.NewSource(time.Now().UnixNano() * int64(os.Getpid())))
-
-type options struct {
-	name      string
-	enableLog bool
-	color     bool
-	out       func(b []byte) (int, error)
-}
-
-func WithName(name string) func(os *options) {
-	return func(os *options) {
-		os.name = name
+// OpenClusterDB opens the primary and replicas from connectors, so that the
+// connect hooks can be installed on each node.
+func OpenClusterDB(driverName string, w driver.Connector, r []driver.Connector, opts ...Option) *ClusterDB {
+	os := newOptions(opts)
+	if err := os.validate(); err != nil {
+		panic(err)
 	}
-}
-
-func WithEnableLog(enableLog bool) func(os *options) {
-	return func(os *options) {
-		os.enableLog = enableLog
+	primaryHooks := append(append([]ConnectHook(nil), os.onConnect...), os.onPrimaryConnect...)
+	replicaHooks := append(append([]ConnectHook(nil), os.onConnect...), os.onReplicaConnect...)
+	var rs []*sql.DB
+	for _, e := range r {
+		rs = append(rs, sql.OpenDB(newSessionConnector(e, replicaHooks...)))
 	}
+	return newClusterDB(sql.OpenDB(newSessionConnector(w, primaryHooks...)), rs, driverName, os)
 }
 
-func WithColor(color bool) func(os *options) {
-	return func(os *options) {
-		os.color = color
+// NewClusterDB panics if opts are invalid, see Validate. Connect hooks need
+// the connectors and are only accepted by OpenClusterDB.
+func NewClusterDB(w *sql.DB, r []*sql.DB, driverName string, opts ...Option) *ClusterDB {
+	os := newOptions(opts)
+	if err := os.validate(); err != nil {
+		panic(err)
 	}
-}
-
-func WithOutput(out func(b []byte) (int, error)) func(os *options) {
-	return func(os *options) {
-		os.out = out
+	if os.hasConnectHooks() {
+		panic(errors.New("sqlxcluster: connect hooks require OpenClusterDB"))
 	}
+	return newClusterDB(w, r, driverName, os)
 }
 
-func NewClusterDB(w *sql.DB, r []*sql.DB, driverName string, opts ...func(os *options)) *ClusterDB {
-	var os options
-	for _, opt := range opts {
-		opt(&os)
-	}
+func newClusterDB(w *sql.DB, r []*sql.DB, driverName string, os options) *ClusterDB {
 	c := &ClusterDB{
-		DB: NewDB(w, driverName),
+		DB:              NewDB(w, driverName),
+		names:           make([]string, len(r)+1),
+		readFromPrimary: os.readFromPrimary,
+		routeObserver:   os.routeObserver,
 	}
 	for _, e := range r {
 		c.r = append(c.r, NewDB(e, driverName))
 	}
-	c.SetName(os.name)
-	c.SetLog(os.enableLog, os.color, os.out)
+	for i := range c.names {
+		if i < len(os.nodeNames) && os.nodeNames[i] != "" {
+			c.names[i] = os.nodeNames[i]
+		} else if i == 0 {
+			c.names[i] = "primary"
+		} else {
+			c.names[i] = "replica-" + strconv.Itoa(i-1)
+		}
+	}
+	c.name.Store(os.name)
+	c.log = newLogSwitch(&os)
+	c.redaction = os.redaction
+	c.interpolate = os.interpolate
+	c.driverName = driverName
+	c.callerSkip = os.callerSkip
+	c.interceptors = os.interceptors
+	c.commentTags = os.commentTags
+	c.explainInterval = os.explainInterval
+	if os.queryStats {
+		c.stats = newQueryStats()
+		c.interceptors = append(append([]Interceptor(nil), c.interceptors...), c.stats)
+	}
+	if os.nPlusOne != nil {
+		d := *os.nPlusOne
+		d.skip = os.callerSkip
+		c.interceptors = append(append([]Interceptor(nil), c.interceptors...), &d)
+	}
+	c.DB = c.wrapNode(c.DB, 0)
+	for i := 0; i < len(c.r); i++ {
+		c.r[i] = c.wrapNode(c.r[i], i+1)
+	}
 	return c
 }
 
@@ -65,42 +92,197 @@ var (
 )
 
 type ClusterDB struct {
-	DB             // write + read
-	r         []DB // only read
-	name      string
-	meta      interface{}
-	enableLog bool
-	color     bool
-	out       func(b []byte) (int, error)
-}
-
+	DB                       // write + read
+	r               []DB     // only read
+	names           []string // primary first, then replicas
+	readFromPrimary bool
+	routeObserver   RouteObserver
+	name            atomic.Value // string
+	meta            interface{}
+	log             *logSwitch
+	redaction       *Redaction
+	interpolate     bool
+	driverName      string
+	callerSkip      []string
+	interceptors    []Interceptor
+	stats           *queryStats // nil unless WithQueryStats
+	commentTags     []CommentTag
+	explainInterval time.Duration
+}
+
+// SetLog enables or disables the logs of every node, see SetLogConfig.
 func (c *ClusterDB) SetLog(enable bool, color bool, out func(b []byte) (int, error)) {
-	if out == nil {
-		out = defaultOut
-	}
-	c.enableLog = enable
-	c.color = color
-	c.out = out
-	r := c.r
-	if enable {
-		c.DB = NewLoggedDB(c.DB, color, out)
-		for i := 0; i < len(r); i++ {
-			r[i] = NewLoggedDB(r[i], color, out)
-		}
-	} else {
-		c.DB = unwrapLoggedDB(c.DB)
-		for i := 0; i < len(r); i++ {
-			r[i] = unwrapLoggedDB(r[i])
+	c.log.update(func(st *logState) {
+		st.enable = enable
+		st.color = color
+		st.out = out
+	})
+}
+
+// wrapNode chains the i-th node, with the query log even when disabled.
+func (c *ClusterDB) wrapNode(db DB, i int) DB {
+	opts := c.nodeLogOptions(i)
+	if c.explainInterval > 0 {
+		if x := newExplainer(db, c.driverName, c.log, c.explainInterval); x != nil {
+			opts = append(opts, withExplainer(x))
 		}
 	}
+	return NewLoggedDB(db, opts...)
+}
+
+type Role int
+
+const (
+	RolePrimary Role = iota
+	RoleReplica
+)
+
+func (r Role) String() string {
+	switch r {
+	case RolePrimary:
+		return "primary"
+	case RoleReplica:
+		return "replica"
+	default:
+		return "unknown"
+	}
+}
+
+// Route is how a read was routed.
+type Route int
+
+const (
+	RouteReplica  Route = iota // to a replica
+	RoutePrimary               // to the primary, picked with the replicas, see WithReadFromPrimary
+	RouteFallback              // to the primary, for lack of replicas
+)
+
+func (r Route) String() string {
+	switch r {
+	case RouteReplica:
+		return "replica"
+	case RoutePrimary:
+		return "primary"
+	case RouteFallback:
+		return "fallback"
+	default:
+		return "unknown"
+	}
+}
+
+// RouteObserver is told the node of every read.
+type RouteObserver interface {
+	ObserveRoute(cluster string, node string, route Route)
+}
+
+type RouteObserverFunc func(cluster string, node string, route Route)
+
+func (f RouteObserverFunc) ObserveRoute(cluster string, node string, route Route) {
+	f(cluster, node, route)
+}
+
+type NodeStats struct {
+	sql.DBStats
+	Name string
+	Role Role
+}
+
+// NodeStats returns the connection pool statistics of the primary followed
+// by every replica.
+func (c *ClusterDB) NodeStats() []NodeStats {
+	stats := []NodeStats{{DBStats: c.DB.Stats(), Name: c.names[0], Role: RolePrimary}}
+	for i := 0; i < len(c.r); i++ {
+		stats = append(stats, NodeStats{
+			DBStats: c.r[i].Stats(),
+			Name:    c.names[i+1],
+			Role:    RoleReplica,
+		})
+	}
+	return stats
 }
 
 func (c *ClusterDB) Name() string {
-	return c.name
+	return c.name.Load().(string)
 }
 
+// SetName names the cluster in the events and the routes.
 func (c *ClusterDB) SetName(name string) {
-	c.name = name
+	c.name.Store(name)
+	for _, node := range append([]DB{c.DB}, c.r...) {
+		if n, ok := node.(interface{ nodeChain() *chain }); ok {
+			n.nodeChain().setCluster(name)
+		}
+	}
+}
+
+// SetLogger sends the query events of every node to logger, or to the text
+// output when logger is nil. It takes effect while logging is enabled.
+func (c *ClusterDB) SetLogger(logger QueryLogger) {
+	c.log.update(func(st *logState) {
+		st.custom = logger
+	})
+}
+
+func (c *ClusterDB) nodeLogOptions(i int) []Option {
+	role := RolePrimary
+	if i > 0 {
+		role = RoleReplica
+	}
+	return []Option{
+		withLogSwitch(c.log),
+		WithName(c.Name()),
+		withNode(c.names[i], role),
+		WithRedaction(c.redaction),
+		WithInterpolate(c.interpolate),
+		withDriver(c.driverName),
+		WithCallerSkip(c.callerSkip...),
+		WithInterceptors(c.interceptors...),
+		WithSQLComment(c.commentTags...),
+	}
+}
+
+// SetSlowThreshold changes the slow query threshold of every node, see
+// WithSlowThreshold. It is safe to call while queries run.
+func (c *ClusterDB) SetSlowThreshold(d time.Duration) {
+	c.log.update(func(st *logState) {
+		st.slow = d
+	})
+}
+
+// SetSampleRate changes the sample rate of every node, see WithSampleRate.
+// It is safe to call while queries run, and fails with a rate out of [0, 1].
+func (c *ClusterDB) SetSampleRate(rate float64) error {
+	if !validSampleRate(rate) {
+		return errSampleRate
+	}
+	c.log.update(func(st *logState) {
+		st.rate = rate
+	})
+	return nil
+}
+
+// SetLongTxThreshold changes the long transaction threshold of every node,
+// see WithLongTxThreshold. It is safe to call while queries run.
+func (c *ClusterDB) SetLongTxThreshold(d time.Duration) {
+	c.log.update(func(st *logState) {
+		st.longTx = d
+	})
+}
+
+// TopQueries returns the statistics of the n first fingerprints in orderBy,
+// all of them when n is not positive, or nil without WithQueryStats.
+func (c *ClusterDB) TopQueries(n int, orderBy OrderBy) []QueryStat {
+	if c.stats == nil {
+		return nil
+	}
+	return c.stats.top(n, orderBy)
+}
+
+// ResetQueryStats forgets the statistics of TopQueries.
+func (c *ClusterDB) ResetQueryStats() {
+	if c.stats != nil {
+		c.stats.reset()
+	}
 }
 
 func (c *ClusterDB) Meta() interface{} {
@@ -142,11 +324,11 @@ func (c *ClusterDB) PingContext(ctx context.Context) error {
 	return nil
 }
 
-func (c *ClusterDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+func (c *ClusterDB) Query(query string, args ...interface{}) (*Rows, error) {
 	return c.db(true).Query(query, args...)
 }
 
-func (c *ClusterDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+func (c *ClusterDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
 	return c.db(true).QueryContext(ctx, query, args...)
 }
 
@@ -174,11 +356,11 @@ func (c *ClusterDB) SelectContext(ctx context.Context, dest interface{}, query s
 	return c.db(true).SelectContext(ctx, dest, query, args...)
 }
 
-func (c *ClusterDB) Queryx(query string, args ...interface{}) (*sqlx.Rows, error) {
+func (c *ClusterDB) Queryx(query string, args ...interface{}) (*Rows, error) {
 	return c.db(true).Queryx(query, args...)
 }
 
-func (c *ClusterDB) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+func (c *ClusterDB) QueryxContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
 	return c.db(true).QueryxContext(ctx, query, args...)
 }
 
@@ -190,11 +372,11 @@ func (c *ClusterDB) QueryRowxContext(ctx context.Context, query string, args ...
 	return c.db(true).QueryRowxContext(ctx, query, args...)
 }
 
-func (c *ClusterDB) NamedQuery(query string, arg interface{}) (*sqlx.Rows, error) {
+func (c *ClusterDB) NamedQuery(query string, arg interface{}) (*Rows, error) {
 	return c.db(true).NamedQuery(query, arg)
 }
 
-func (c *ClusterDB) NamedQueryContext(ctx context.Context, query string, arg interface{}) (*sqlx.Rows, error) {
+func (c *ClusterDB) NamedQueryContext(ctx context.Context, query string, arg interface{}) (*Rows, error) {
 	// return c.db(true).NamedQueryContxt(ctx, query, arg)
 	return c.db(true).NamedQuery(query, arg)
 }
@@ -203,24 +385,41 @@ func (c *ClusterDB) db(readOnly bool) DB {
 	if !readOnly {
 		return c.DB
 	}
-	switch len(c.r) {
-	case 0:
+	n := len(c.r)
+	if n == 0 {
+		return c.route(-1, RouteFallback)
+	}
+	if c.readFromPrimary {
+		n++
+	}
+	if n == 1 {
+		return c.route(0, RouteReplica)
+	}
+	if i := rand.Intn(n); i < len(c.r) {
+		return c.route(i, RouteReplica)
+	}
+	return c.route(-1, RoutePrimary)
+}
+
+// route returns the i-th replica, or the primary when i is negative.
+func (c *ClusterDB) route(i int, route Route) DB {
+	if c.routeObserver != nil {
+		c.routeObserver.ObserveRoute(c.Name(), c.names[i+1], route)
+	}
+	if i < 0 {
 		return c.DB
-	case 1:
-		return c.r[0]
-	default:
-		return c.r[rn.Intn(len(c.r))]
 	}
+	return c.r[i]
 }
 
 func (c *ClusterDB) Logged() bool {
-	return c.enableLog
+	return c.log.load().enable
 }
 
 func (c *ClusterDB) Colored() bool {
-	return c.color
+	return c.log.load().color
 }
 
 func (c *ClusterDB) Output() func(b []byte) (int, error) {
-	return c.out
+	return c.log.load().out
 }
@@ -0,0 +1,78 @@
+package sqlxcluster
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-comm/sqlxcluster/internal/fakedriver"
+)
+
+func TestClusterInvalidOptions(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic")
+		}
+	}()
+	d := fakedriver.New()
+	OpenClusterDB(d.Name, d.Connector("primary"), nil, WithSlowThreshold(-time.Second))
+}
+
+func TestClusterRouteObserver(t *testing.T) {
+	d := fakedriver.New()
+	var routes []string
+	observer := RouteObserverFunc(func(cluster string, node string, route Route) {
+		routes = append(routes, cluster+" "+node+" "+route.String())
+	})
+	c := OpenClusterDB(d.Name, d.Connector("primary"), []driver.Connector{d.Connector("replica")},
+		WithName("users"), WithRouteObserver(observer))
+	defer c.Close()
+
+	c.Exec("update t set a = 1")
+	c.Query("select 1")
+	if len(routes) != 1 || routes[0] != "users replica-0 replica" {
+		t.Fatalf("unexpected routes %q", routes)
+	}
+
+	routes = nil
+	c = OpenClusterDB(d.Name, d.Connector("primary"), []driver.Connector{d.Connector("replica")},
+		WithName("users"), WithRouteObserver(observer), WithReadFromPrimary(true))
+	defer c.Close()
+	for i := 0; i < 100; i++ {
+		c.Query("select 1")
+	}
+	if got := strings.Join(routes, ","); !strings.Contains(got, "users primary primary") || !strings.Contains(got, "users replica-0 replica") {
+		t.Fatalf("expected reads on both nodes, got %q", routes)
+	}
+
+	routes = nil
+	c = OpenClusterDB(d.Name, d.Connector("primary"), nil, WithName("orders"), WithRouteObserver(observer))
+	defer c.Close()
+	c.Query("select 1")
+	if len(routes) != 1 || routes[0] != "orders primary fallback" {
+		t.Fatalf("unexpected routes without replicas %q", routes)
+	}
+
+	// Renamed while in use.
+	done := make(chan struct{})
+	go func() {
+		c.SetName("sales")
+		close(done)
+	}()
+	c.Query("select 1")
+	<-done
+	c.Query("select 1")
+	if routes[len(routes)-1] != "sales primary fallback" {
+		t.Fatalf("unexpected route after renaming %q", routes[len(routes)-1])
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate(WithSampleRate(2)); err == nil {
+		t.Fatal("expected an invalid sample rate")
+	}
+	if err := Validate(WithSampleRate(0.5), WithSlowThreshold(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+}
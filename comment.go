@@ -0,0 +1,114 @@
+package sqlxcluster
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// CommentTag is a key of the comments of WithSQLComment and how to find its
+// value. Tags with an empty value are left out.
+type CommentTag struct {
+	Key    string
+	Value  func(ctx context.Context, e *QueryEvent) string
+	caller bool
+}
+
+// ContextTag tags the statements with the value stored in their context under
+// ctxKey, such as a request ID or an HTTP route, formatted with fmt.Sprint.
+func ContextTag(key string, ctxKey interface{}) CommentTag {
+	return CommentTag{Key: key, Value: func(ctx context.Context, e *QueryEvent) string {
+		v := ctx.Value(ctxKey)
+		if v == nil {
+			return ""
+		}
+		return fmt.Sprint(v)
+	}}
+}
+
+// CallerTag tags the statements with the function running them, found as for
+// the logs, see WithCallerSkip.
+func CallerTag(key string) CommentTag {
+	return CommentTag{Key: key, caller: true, Value: func(ctx context.Context, e *QueryEvent) string {
+		return e.Caller.Function
+	}}
+}
+
+type commentKey struct{}
+
+type preparedKey struct{}
+
+// withPrepared marks the executions of prepared statements.
+func withPrepared(ctx context.Context) context.Context {
+	return context.WithValue(ctx, preparedKey{}, true)
+}
+
+// tagged returns query with the comment chosen for the statement of ctx.
+func tagged(ctx context.Context, query string) string {
+	comment, ok := ctx.Value(commentKey{}).(string)
+	if !ok {
+		return query
+	}
+	q := strings.TrimRight(query, " \t\r\n;")
+	return q + comment + query[len(q):]
+}
+
+// commenter is the interceptor of WithSQLComment, run last.
+type commenter struct {
+	tags    []CommentTag
+	skip    []string
+	caller  bool
+	dialect dialect
+}
+
+func newCommenter(tags []CommentTag, skip []string, driverName string) *commenter {
+	c := &commenter{tags: tags, skip: skip, dialect: dialectOf(driverName)}
+	for _, tag := range tags {
+		c.caller = c.caller || tag.caller
+	}
+	return c
+}
+
+func (c *commenter) Before(ctx context.Context, e *QueryEvent) (context.Context, error) {
+	switch e.Op {
+	case OpPrepare, OpPrepareNamed, OpBegin, OpCommit, OpRollback:
+		// Prepared statements are keyed on their text by the drivers.
+		return ctx, nil
+	}
+	if ctx.Value(preparedKey{}) != nil || hasComment(c.dialect, e.Query) {
+		return ctx, nil
+	}
+	if c.caller && e.Caller == (Caller{}) {
+		e.Caller = findCaller(c.skip)
+	}
+	var pairs []string
+	for _, tag := range c.tags {
+		if v := tag.Value(ctx, e); v != "" {
+			pairs = append(pairs, commentEscape(tag.Key)+"='"+commentEscape(v)+"'")
+		}
+	}
+	if len(pairs) == 0 {
+		return ctx, nil
+	}
+	sort.Strings(pairs)
+	return context.WithValue(ctx, commentKey{}, " /*"+strings.Join(pairs, ",")+"*/"), nil
+}
+
+func (c *commenter) After(ctx context.Context, e *QueryEvent) {}
+
+// hasComment reports whether query has a comment already.
+func hasComment(d dialect, query string) bool {
+	for _, t := range lexSQL(d, query) {
+		if t.kind == tokComment {
+			return true
+		}
+	}
+	return false
+}
+
+// commentEscape URL-encodes s.
+func commentEscape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
@@ -0,0 +1,76 @@
+package sqlxcluster
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-comm/sqlxcluster/internal/fakedriver"
+)
+
+type (
+	requestIDKey struct{}
+	routeKey     struct{}
+)
+
+func TestSQLComment(t *testing.T) {
+	d := fakedriver.New()
+	var queries []string
+	c := OpenClusterDB(d.Name, d.Connector("primary"), nil,
+		WithSQLComment(ContextTag("request_id", requestIDKey{}), ContextTag("route", routeKey{})),
+		WithInterceptors(InterceptorFuncs{AfterFunc: func(ctx context.Context, e *QueryEvent) {
+			queries = append(queries, e.Query)
+		}}))
+	defer c.Close()
+
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "r-1")
+	ctx = context.WithValue(ctx, routeKey{}, "/users/{id} */ 'x'")
+	c.ExecContext(ctx, "update t set a = 1;")
+	c.Exec("update t set a = 2 -- no tags")
+	stmt, err := c.PreparexContext(ctx, "update t set a = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt.ExecContext(ctx, 3)
+	stmt.Close()
+
+	execs := d.Execs()
+	want := "update t set a = 1 /*request_id='r-1'," +
+		"route='%2Fusers%2F%7Bid%7D%20%2A%2F%20%27x%27'*/;"
+	if len(execs) != 3 || execs[0] != want {
+		t.Fatalf("unexpected tagged statement %q", execs)
+	}
+	if execs[1] != "update t set a = 2 -- no tags" || execs[2] != "update t set a = ?" {
+		t.Fatalf("expected untagged statements, got %q", execs[1:])
+	}
+
+	if queries[0] != "update t set a = 1;" {
+		t.Fatalf("expected the event to keep the statement as written, got %q", queries[0])
+	}
+
+	// Statements of a pinned connection are tagged by the connection.
+	conn, err := c.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.ExecContext(ctx, "update t set a = 4")
+	if execs := d.Execs(); len(execs) != 4 || execs[3] != "update t set a = 4 /*request_id='r-1',route='%2Fusers%2F%7Bid%7D%20%2A%2F%20%27x%27'*/" {
+		t.Fatalf("unexpected tagged statement %q", execs)
+	}
+	if last := queries[len(queries)-1]; last != "update t set a = 4" {
+		t.Fatalf("expected the event to keep the statement as written, got %q", last)
+	}
+
+	// Distinct comments reach the driver as statements run without being
+	// prepared, so that nothing is cached for them once the statement cache
+	// of the driver is disabled.
+	for _, id := range []string{"r-2", "r-3"} {
+		ctx := context.WithValue(context.Background(), requestIDKey{}, id)
+		var ns []int
+		c.ExecContext(ctx, "update t set a = ?", 5)
+		c.SelectContext(ctx, &ns, "select 2 from t where a = ?", 5)
+	}
+	if prepared := d.Prepared(); len(prepared) != 1 || prepared[0] != "update t set a = ?" {
+		t.Fatalf("expected only the prepared statement to be prepared, got %q", prepared)
+	}
+}
@@ -0,0 +1,196 @@
+package sqlxcluster
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	_ driver.Connector   = (*credentialConnector)(nil)
+	_ driver.Connector   = (*sessionConnector)(nil)
+	_ CredentialProvider = CredentialProviderFunc(nil)
+	_ CredentialProvider = (*fileCredentialProvider)(nil)
+)
+
+// CredentialProvider returns the DSN used to open new connections. It is
+// consulted on every new physical connection, so rotated credentials are
+// picked up without recreating the pool.
+type CredentialProvider interface {
+	DSN(ctx context.Context) (string, error)
+}
+
+type CredentialProviderFunc func(ctx context.Context) (string, error)
+
+func (f CredentialProviderFunc) DSN(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// NewFileCredentialProvider reads the secret stored at path and builds the
+// DSN with dsn. The file is read for every new connection, so that a rotation
+// is seen even when it keeps the size and the modification time of the file.
+func NewFileCredentialProvider(path string, dsn func(secret string) string) CredentialProvider {
+	return &fileCredentialProvider{path: path, dsn: dsn}
+}
+
+type fileCredentialProvider struct {
+	mutex  sync.Mutex
+	path   string
+	dsn    func(secret string) string
+	secret string
+	value  string
+}
+
+func (p *fileCredentialProvider) DSN(ctx context.Context) (string, error) {
+	b, err := os.ReadFile(p.path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimSpace(string(b))
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.value != "" && secret == p.secret {
+		return p.value, nil
+	}
+	p.secret = secret
+	p.value = secret
+	if p.dsn != nil {
+		p.value = p.dsn(secret)
+	}
+	return p.value, nil
+}
+
+// NewCredentialConnector returns a connector of d that asks provider for the
+// DSN each time a connection is opened. Connections that are already open
+// keep working when the credentials change. onError, if not nil, is called
+// when the provider or the driver fails to open a connection.
+func NewCredentialConnector(d driver.Driver, provider CredentialProvider, onError func(err error)) (driver.Connector, error) {
+	if d == nil {
+		return nil, fmt.Errorf("sqlxcluster: nil driver")
+	}
+	if provider == nil {
+		return nil, fmt.Errorf("sqlxcluster: nil credential provider")
+	}
+	return &credentialConnector{driver: d, provider: provider, onError: onError}, nil
+}
+
+type credentialConnector struct {
+	mutex     sync.Mutex
+	driver    driver.Driver
+	provider  CredentialProvider
+	onError   func(err error)
+	dsn       string
+	connector driver.Connector
+}
+
+func (c *credentialConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	dsn, err := c.provider.DSN(ctx)
+	if err != nil {
+		c.fail(err)
+		return nil, err
+	}
+	connector, err := c.open(dsn)
+	if err != nil {
+		c.fail(err)
+		return nil, err
+	}
+	var conn driver.Conn
+	if connector != nil {
+		conn, err = connector.Connect(ctx)
+	} else {
+		conn, err = c.driver.Open(dsn)
+	}
+	if err != nil {
+		c.fail(err)
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (c *credentialConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+func (c *credentialConnector) open(dsn string) (driver.Connector, error) {
+	dc, ok := c.driver.(driver.DriverContext)
+	if !ok {
+		return nil, nil
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.connector != nil && c.dsn == dsn {
+		return c.connector, nil
+	}
+	connector, err := dc.OpenConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	c.dsn = dsn
+	c.connector = connector
+	return connector, nil
+}
+
+func (c *credentialConnector) fail(err error) {
+	if f := c.onError; f != nil {
+		f(err)
+	}
+}
+
+type ConnectHook func(ctx context.Context, conn driver.Conn) error
+
+// ExecConn runs query on a raw driver connection. It is meant for session
+// setup statements inside a ConnectHook.
+func ExecConn(ctx context.Context, conn driver.Conn, query string) error {
+	if e, ok := conn.(driver.ExecerContext); ok {
+		_, err := e.ExecContext(ctx, query, nil)
+		if err != driver.ErrSkip {
+			return err
+		}
+	}
+	var stmt driver.Stmt
+	var err error
+	if p, ok := conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = conn.Prepare(query)
+	}
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	if e, ok := stmt.(driver.StmtExecContext); ok {
+		_, err = e.ExecContext(ctx, nil)
+		return err
+	}
+	_, err = stmt.Exec(nil)
+	return err
+}
+
+func newSessionConnector(connector driver.Connector, hooks ...ConnectHook) driver.Connector {
+	if len(hooks) == 0 {
+		return connector
+	}
+	return &sessionConnector{Connector: connector, hooks: hooks}
+}
+
+type sessionConnector struct {
+	driver.Connector
+	hooks []ConnectHook
+}
+
+func (c *sessionConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, hook := range c.hooks {
+		if err := hook(ctx, conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
@@ -0,0 +1,134 @@
+package sqlxcluster
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-comm/sqlxcluster/internal/fakedriver"
+)
+
+func TestCredentialConnectorRotation(t *testing.T) {
+	d := fakedriver.New()
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte("one\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var failures int
+	connector, err := NewCredentialConnector(d, NewFileCredentialProvider(path, func(secret string) string {
+		return "user:" + secret
+	}), func(err error) {
+		failures++
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	ctx := context.Background()
+	c1, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+
+	// Same size, and most likely the same modification time.
+	if err := os.WriteFile(path, []byte("two\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c2, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+
+	if err := c1.PingContext(ctx); err != nil {
+		t.Fatalf("old connection broken: %v", err)
+	}
+	opened := d.Opened()
+	if len(opened) != 2 || opened[0] != "user:one" || opened[1] != "user:two" {
+		t.Fatalf("unexpected dsns: %v", opened)
+	}
+
+	connector, _ = NewCredentialConnector(d, CredentialProviderFunc(func(ctx context.Context) (string, error) {
+		return "bad:password", nil
+	}), func(err error) {
+		failures++
+	})
+	if _, err := connector.Connect(ctx); err == nil {
+		t.Fatal("expected authentication failure")
+	}
+	if failures != 1 {
+		t.Fatalf("expected 1 failure callback, got %d", failures)
+	}
+}
+
+func TestCredentialConnectorDriverContext(t *testing.T) {
+	d := fakedriver.New().Context()
+	dsn := "user:one"
+	connector, err := NewCredentialConnector(d, CredentialProviderFunc(func(ctx context.Context) (string, error) {
+		return dsn, nil
+	}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if connector.Driver() != d {
+		t.Fatal("expected the driver of the connector")
+	}
+
+	ctx := context.Background()
+	for _, next := range []string{"user:one", "user:two", "user:two"} {
+		dsn = next
+		conn, err := connector.Connect(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+	if connectors := d.Connectors(); strings.Join(connectors, ",") != "user:one,user:two" {
+		t.Fatalf("expected a connector per dsn, got %v", connectors)
+	}
+	if opened := d.Opened(); strings.Join(opened, ",") != "user:one,user:two,user:two" {
+		t.Fatalf("unexpected dsns: %v", opened)
+	}
+}
+
+func TestSessionConnectHooks(t *testing.T) {
+	d := fakedriver.New()
+	setup := func(query string) ConnectHook {
+		return func(ctx context.Context, conn driver.Conn) error {
+			return ExecConn(ctx, conn, query)
+		}
+	}
+	c := OpenClusterDB(d.Name, d.Connector("primary"), []driver.Connector{d.Connector("replica")},
+		WithOnConnect(setup("set time_zone = '+00:00'")),
+		WithPrimaryOnConnect(setup("set application_name = 'w'")),
+		WithReplicaOnConnect(setup("set application_name = 'r'")),
+	)
+	defer c.Close()
+
+	if err := c.W().Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.R().Ping(); err != nil {
+		t.Fatal(err)
+	}
+	execs := strings.Join(d.Execs(), "; ")
+	want := "set time_zone = '+00:00'; set application_name = 'w'; set time_zone = '+00:00'; set application_name = 'r'"
+	if execs != want {
+		t.Fatalf("unexpected session statements: %s", execs)
+	}
+
+	c = OpenClusterDB(d.Name, d.Connector("primary"), nil, WithOnConnect(setup("fail")))
+	defer c.Close()
+	if err := c.Ping(); err == nil {
+		t.Fatal("expected connect hook failure")
+	}
+}
@@ -11,16 +11,16 @@ import (
 
 var _ sql.Tx
 var _ sql.DB
-var _ DB = (*sqlx.DB)(nil)
-var _ Tx = (*sqlx.Tx)(nil)
+var _ DB = (*wrappedDB)(nil)
+var _ Tx = (*wrappedTx)(nil)
 
 type Command interface {
 	Exec(query string, args ...interface{}) (sql.Result, error)
 	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
-	Prepare(query string) (*sql.Stmt, error)
-	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
-	Query(query string, args ...interface{}) (*sql.Rows, error)
-	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	Prepare(query string) (*Stmt, error)
+	PrepareContext(ctx context.Context, query string) (*Stmt, error)
+	Query(query string, args ...interface{}) (*Rows, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error)
 	QueryRow(query string, args ...interface{}) *sql.Row
 	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 
@@ -28,28 +28,28 @@ type Command interface {
 	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
 	NamedExec(query string, arg interface{}) (sql.Result, error)
 	NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error)
-	NamedQuery(query string, arg interface{}) (*sqlx.Rows, error)
-	// NamedQueryContext(ctx context.Context, query string, arg interface{}) (*sqlx.Rows, error)
-	PrepareNamed(query string) (*sqlx.NamedStmt, error)
-	PrepareNamedContext(ctx context.Context, query string) (*sqlx.NamedStmt, error)
-	Preparex(query string) (*sqlx.Stmt, error)
-	PreparexContext(ctx context.Context, query string) (*sqlx.Stmt, error)
+	NamedQuery(query string, arg interface{}) (*Rows, error)
+	// NamedQueryContext(ctx context.Context, query string, arg interface{}) (*Rows, error)
+	PrepareNamed(query string) (*NamedStmt, error)
+	PrepareNamedContext(ctx context.Context, query string) (*NamedStmt, error)
+	Preparex(query string) (*Stmt, error)
+	PreparexContext(ctx context.Context, query string) (*Stmt, error)
 	QueryRowx(query string, args ...interface{}) *sqlx.Row
 	QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row
-	Queryx(query string, args ...interface{}) (*sqlx.Rows, error)
-	QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error)
+	Queryx(query string, args ...interface{}) (*Rows, error)
+	QueryxContext(ctx context.Context, query string, args ...interface{}) (*Rows, error)
 	Select(dest interface{}, query string, args ...interface{}) error
 	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
 }
 
 type DB interface {
 	Driver() driver.Driver
-	Conn(ctx context.Context) (*sql.Conn, error)
+	Conn(ctx context.Context) (*Conn, error)
 	Ping() error
 	PingContext(ctx context.Context) error
 	Close() error
-	Begin() (*sql.Tx, error)
-	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	Begin() (Tx, error)
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error)
 	SetConnMaxIdleTime(d time.Duration)
 	SetConnMaxLifetime(d time.Duration)
 	SetMaxIdleConns(n int)
@@ -57,9 +57,9 @@ type DB interface {
 	Stats() sql.DBStats
 
 	DriverName() string
-	Connx(ctx context.Context) (*sqlx.Conn, error)
-	Beginx() (*sqlx.Tx, error)
-	BeginTxx(ctx context.Context, opts *sql.TxOptions) (*sqlx.Tx, error)
+	Connx(ctx context.Context) (*Conn, error)
+	Beginx() (Tx, error)
+	BeginTxx(ctx context.Context, opts *sql.TxOptions) (Tx, error)
 
 	Command
 }
@@ -84,24 +84,151 @@ func (w *wrappedDB) Unwrap() *sql.DB {
 	return w.DB.DB
 }
 
-func Begin(db DB) (tx Tx, err error) {
-	tx, err = db.Beginx()
+func (w *wrappedDB) Query(query string, args ...interface{}) (*Rows, error) {
+	return newRows(w.DB.Queryx(query, args...))
+}
+
+func (w *wrappedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return newRows(w.DB.QueryxContext(ctx, query, args...))
+}
+
+func (w *wrappedDB) Queryx(query string, args ...interface{}) (*Rows, error) {
+	return newRows(w.DB.Queryx(query, args...))
+}
+
+func (w *wrappedDB) QueryxContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return newRows(w.DB.QueryxContext(ctx, query, args...))
+}
+
+func (w *wrappedDB) NamedQuery(query string, arg interface{}) (*Rows, error) {
+	return newRows(w.DB.NamedQuery(query, arg))
+}
+
+func (w *wrappedDB) Prepare(query string) (*Stmt, error) {
+	return w.PreparexContext(context.Background(), query)
+}
+
+func (w *wrappedDB) PrepareContext(ctx context.Context, query string) (*Stmt, error) {
+	return w.PreparexContext(ctx, query)
+}
+
+func (w *wrappedDB) Preparex(query string) (*Stmt, error) {
+	return w.PreparexContext(context.Background(), query)
+}
+
+func (w *wrappedDB) PreparexContext(ctx context.Context, query string) (*Stmt, error) {
+	s, err := w.DB.PreparexContext(ctx, query)
 	if err != nil {
-		return tx, err
+		return nil, err
 	}
-	if ldb, ok := db.(logged); ok && ldb.Logged() {
-		tx = NewLoggedTx(tx, ldb.Colored(), ldb.Output())
+	return &Stmt{Stmt: s, c: &command{chain: bare}, query: query}, nil
+}
+
+func (w *wrappedDB) PrepareNamed(query string) (*NamedStmt, error) {
+	return w.PrepareNamedContext(context.Background(), query)
+}
+
+func (w *wrappedDB) PrepareNamedContext(ctx context.Context, query string) (*NamedStmt, error) {
+	s, err := w.DB.PrepareNamedContext(ctx, query)
+	if err != nil {
+		return nil, err
 	}
-	return tx, err
+	return &NamedStmt{NamedStmt: s, c: &command{chain: bare}, query: query}, nil
 }
 
-func BeginTx(db DB, ctx context.Context, opts *sql.TxOptions) (tx Tx, err error) {
-	tx, err = db.BeginTxx(ctx, opts)
+func (w *wrappedDB) Conn(ctx context.Context) (*Conn, error) {
+	return w.Connx(ctx)
+}
+
+func (w *wrappedDB) Connx(ctx context.Context) (*Conn, error) {
+	conn, err := w.DB.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: conn, c: bare}, nil
+}
+
+func (w *wrappedDB) Begin() (Tx, error) {
+	return w.BeginTxx(context.Background(), nil)
+}
+
+func (w *wrappedDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	return w.BeginTxx(ctx, opts)
+}
+
+func (w *wrappedDB) Beginx() (Tx, error) {
+	return w.BeginTxx(context.Background(), nil)
+}
+
+func (w *wrappedDB) BeginTxx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	tx, err := w.DB.BeginTxx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &wrappedTx{Tx: tx}, nil
+}
+
+// wrappedTx is a transaction of a wrappedDB.
+type wrappedTx struct {
+	*sqlx.Tx
+}
+
+func (w *wrappedTx) Query(query string, args ...interface{}) (*Rows, error) {
+	return newRows(w.Tx.Queryx(query, args...))
+}
+
+func (w *wrappedTx) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return newRows(w.Tx.QueryxContext(ctx, query, args...))
+}
+
+func (w *wrappedTx) Queryx(query string, args ...interface{}) (*Rows, error) {
+	return newRows(w.Tx.Queryx(query, args...))
+}
+
+func (w *wrappedTx) QueryxContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return newRows(w.Tx.QueryxContext(ctx, query, args...))
+}
+
+func (w *wrappedTx) NamedQuery(query string, arg interface{}) (*Rows, error) {
+	return newRows(w.Tx.NamedQuery(query, arg))
+}
+
+func (w *wrappedTx) Prepare(query string) (*Stmt, error) {
+	return w.PreparexContext(context.Background(), query)
+}
+
+func (w *wrappedTx) PrepareContext(ctx context.Context, query string) (*Stmt, error) {
+	return w.PreparexContext(ctx, query)
+}
+
+func (w *wrappedTx) Preparex(query string) (*Stmt, error) {
+	return w.PreparexContext(context.Background(), query)
+}
+
+func (w *wrappedTx) PreparexContext(ctx context.Context, query string) (*Stmt, error) {
+	s, err := w.Tx.PreparexContext(ctx, query)
 	if err != nil {
-		return tx, err
+		return nil, err
 	}
-	if ldb, ok := db.(logged); ok && ldb.Logged() {
-		tx = NewLoggedTx(tx, ldb.Colored(), ldb.Output())
+	return &Stmt{Stmt: s, c: &command{chain: bare}, query: query}, nil
+}
+
+func (w *wrappedTx) PrepareNamed(query string) (*NamedStmt, error) {
+	return w.PrepareNamedContext(context.Background(), query)
+}
+
+func (w *wrappedTx) PrepareNamedContext(ctx context.Context, query string) (*NamedStmt, error) {
+	s, err := w.Tx.PrepareNamedContext(ctx, query)
+	if err != nil {
+		return nil, err
 	}
-	return tx, err
+	return &NamedStmt{NamedStmt: s, c: &command{chain: bare}, query: query}, nil
+}
+
+func Begin(db DB) (tx Tx, err error) {
+	return db.Beginx()
+}
+
+func BeginTx(db DB, ctx context.Context, opts *sql.TxOptions) (tx Tx, err error) {
+	return db.BeginTxx(ctx, opts)
 }
@@ -0,0 +1,239 @@
+package sqlxcluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// explainTimeout bounds the EXPLAIN run after a slow read.
+const explainTimeout = 5 * time.Second
+
+// maxExplainPlans bounds the plans kept by fingerprint.
+const maxExplainPlans = 1000
+
+type explainPlan struct {
+	plan     string
+	fullScan bool
+	time     time.Time
+	done     chan struct{} // closed once the EXPLAIN ran
+}
+
+// explainer is the interceptor of WithExplain on one node.
+type explainer struct {
+	db       DB
+	dialect  dialect
+	bindType int
+	sw       *logSwitch
+	interval time.Duration
+	mutex    sync.Mutex
+	plans    map[string]*explainPlan
+}
+
+func newExplainer(db DB, driverName string, sw *logSwitch, interval time.Duration) *explainer {
+	d := dialectOf(driverName)
+	switch d {
+	case dialectMySQL, dialectPostgres, dialectSQLite:
+	default:
+		return nil
+	}
+	return &explainer{
+		db:       db,
+		dialect:  d,
+		bindType: sqlx.BindType(driverName),
+		sw:       sw,
+		interval: interval,
+		plans:    make(map[string]*explainPlan),
+	}
+}
+
+func (x *explainer) Before(ctx context.Context, e *QueryEvent) (context.Context, error) {
+	return ctx, nil
+}
+
+func (x *explainer) After(ctx context.Context, e *QueryEvent) {
+	switch e.Op {
+	case OpQuery, OpQueryRow, OpGet, OpSelect, OpNamedQuery:
+	default:
+		return
+	}
+	slow := x.sw.load().slow
+	if slow <= 0 || e.Duration < slow || e.Failed() {
+		return
+	}
+	fingerprint := fingerprint(x.dialect, e.Query)
+	if !strings.HasPrefix(fingerprint, "select") && !strings.HasPrefix(fingerprint, "with") {
+		return
+	}
+
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	prev := x.plans[fingerprint]
+	if prev != nil {
+		e.Plan, e.FullScan = prev.plan, prev.fullScan
+		if time.Since(prev.time) < x.interval {
+			return
+		}
+	}
+	query, args, err := x.explainQuery(e)
+	if err != nil {
+		return
+	}
+	// The fingerprint is claimed until the EXPLAIN fails or the interval
+	// ends. The plan found before, if any, is kept meanwhile.
+	if len(x.plans) >= maxExplainPlans {
+		x.plans = make(map[string]*explainPlan)
+	}
+	p := &explainPlan{time: time.Now(), done: make(chan struct{})}
+	if prev != nil {
+		p.plan, p.fullScan = prev.plan, prev.fullScan
+	}
+	x.plans[fingerprint] = p
+	e.explain = p
+	go x.explain(detached{ctx}, fingerprint, prev, p, query, args)
+}
+
+// explainQuery returns the EXPLAIN of the statement of e.
+func (x *explainer) explainQuery(e *QueryEvent) (string, []interface{}, error) {
+	query, args := e.Query, e.Args
+	if e.Arg != nil {
+		var err error
+		if query, args, err = sqlx.Named(query, e.Arg); err != nil {
+			return "", nil, err
+		}
+		query = sqlx.Rebind(x.bindType, query)
+	}
+	switch x.dialect {
+	case dialectMySQL:
+		query = "EXPLAIN FORMAT=JSON " + query
+	case dialectPostgres:
+		query = "EXPLAIN (FORMAT JSON) " + query
+	case dialectSQLite:
+		query = "EXPLAIN QUERY PLAN " + query
+	}
+	return query, args, nil
+}
+
+// explain records the plan of query in p, unreported to the interceptors.
+func (x *explainer) explain(ctx context.Context, fingerprint string, prev *explainPlan, p *explainPlan, query string, args []interface{}) {
+	defer close(p.done)
+	plan, fullScan, err := x.runExplain(ctx, query, args)
+	x.mutex.Lock()
+	defer x.mutex.Unlock()
+	if err == nil {
+		p.plan, p.fullScan = plan, fullScan
+		return
+	}
+	if x.plans[fingerprint] == p {
+		if prev != nil {
+			x.plans[fingerprint] = prev
+		} else {
+			delete(x.plans, fingerprint)
+		}
+	}
+}
+
+func (x *explainer) runExplain(ctx context.Context, query string, args []interface{}) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, explainTimeout)
+	defer cancel()
+	rows, err := x.db.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return "", false, err
+	}
+	defer rows.Close()
+	var lines []string
+	for rows.Next() {
+		values, err := rows.SliceScan()
+		if err != nil {
+			return "", false, err
+		}
+		if len(values) == 0 {
+			continue
+		}
+		// The JSON plans are in the first column, the details of the
+		// SQLite plans in the last one.
+		v := values[0]
+		if x.dialect == dialectSQLite {
+			v = values[len(values)-1]
+		}
+		if b, ok := v.([]byte); ok {
+			v = string(b)
+		}
+		lines = append(lines, fmt.Sprint(v))
+	}
+	if err := rows.Err(); err != nil {
+		return "", false, err
+	}
+	plan := strings.Join(lines, "\n")
+	return plan, x.fullScan(plan), nil
+}
+
+// detached keeps the values of a context but not its cancellation.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detached) Done() <-chan struct{} {
+	return nil
+}
+
+func (detached) Err() error {
+	return nil
+}
+
+// fullScan reports whether plan reads a whole table.
+func (x *explainer) fullScan(plan string) bool {
+	switch x.dialect {
+	case dialectSQLite:
+		for _, line := range strings.Split(plan, "\n") {
+			if strings.HasPrefix(line, "SCAN ") && !strings.Contains(line, " USING ") {
+				return true
+			}
+		}
+		return false
+	case dialectMySQL:
+		return planHas(plan, "access_type", "ALL")
+	case dialectPostgres:
+		return planHas(plan, "Node Type", "Seq Scan")
+	}
+	return false
+}
+
+// planHas reports whether the JSON plan has key set to value at any depth.
+func planHas(plan string, key string, value string) bool {
+	var v interface{}
+	if err := json.Unmarshal([]byte(plan), &v); err != nil {
+		return false
+	}
+	var walk func(v interface{}) bool
+	walk = func(v interface{}) bool {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, e := range v {
+				if s, ok := e.(string); ok && k == key && s == value {
+					return true
+				}
+				if walk(e) {
+					return true
+				}
+			}
+		case []interface{}:
+			for _, e := range v {
+				if walk(e) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return walk(v)
+}
@@ -0,0 +1,117 @@
+package sqlxcluster
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-comm/sqlxcluster/internal/fakedriver"
+)
+
+func TestExplainSlowReads(t *testing.T) {
+	d := fakedriver.New()
+	var mutex sync.Mutex
+	var events []*QueryEvent
+	c := OpenClusterDB("sqlite3", d.Connector("primary"), nil, WithEnableLog(true),
+		WithSlowThreshold(time.Nanosecond), WithExplain(time.Hour),
+		WithLogger(QueryLoggerFunc(func(ctx context.Context, e *QueryEvent) {
+			mutex.Lock()
+			events = append(events, e)
+			mutex.Unlock()
+		})))
+	defer c.Close()
+	logged := func(n int) []*QueryEvent {
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+			mutex.Lock()
+			ls := append([]*QueryEvent(nil), events...)
+			mutex.Unlock()
+			if len(ls) >= n || time.Now().After(deadline) {
+				return ls
+			}
+		}
+	}
+
+	// The EXPLAIN outlives the context of the read, and the read that ran it
+	// is logged with the plan.
+	ctx, cancel := context.WithCancel(context.Background())
+	var ns []int
+	if err := c.SelectContext(ctx, &ns, "select 2 from t where a = ?", 1); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if e := logged(1)[0]; !e.Slow || e.Plan != "SCAN t" || !e.FullScan {
+		t.Fatalf("unexpected slow read %+v", e)
+	}
+	c.Select(&ns, "select 2 from t where a = ?", 2)
+	if e := logged(2)[1]; e.Plan != "SCAN t" {
+		t.Fatalf("expected the plan found to be reused, got %q", e.Plan)
+	}
+	c.Exec("update t set a = 1")
+	evs := logged(3)
+	if e := evs[2]; e.Op != OpExec || e.Plan != "" {
+		t.Fatalf("unexpected plan for a write %q", e.Plan)
+	}
+	if explains := d.Explains(); len(explains) != 1 || explains[0] != "EXPLAIN QUERY PLAN select 2 from t where a = ?" {
+		t.Fatalf("expected a single EXPLAIN, got %q", explains)
+	}
+	for _, e := range evs {
+		if e.Op == OpQuery {
+			t.Fatalf("expected the EXPLAIN not to be logged, got %+v", e)
+		}
+	}
+
+	// A failed EXPLAIN is tried again with the next slow read.
+	c.Select(&ns, "select 2 from noplan")
+	logged(4)
+	c.Select(&ns, "select 2 from noplan")
+	if evs := logged(5); len(evs) != 5 || evs[3].Plan != "" || evs[4].Plan != "" {
+		t.Fatalf("unexpected events %+v", evs)
+	}
+	if explains := d.Explains(); len(explains) != 3 || explains[2] != "EXPLAIN QUERY PLAN select 2 from noplan" {
+		t.Fatalf("expected the EXPLAIN to run again, got %q", explains)
+	}
+
+	// The EXPLAIN waits for the only connection, held by the transaction,
+	// rather than holding the transaction.
+	c.SetMaxOpenConns(1)
+	tx, err := Begin(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	tx.Select(&ns, "select 2 from v")
+	tx.Commit()
+	if time.Since(start) >= explainTimeout {
+		t.Fatal("expected the transaction not to wait for the EXPLAIN")
+	}
+
+	c.SetSlowThreshold(time.Hour)
+	c.Select(&ns, "select 2 from u")
+	for deadline := time.Now().Add(5 * time.Second); len(d.Explains()) < 4 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if explains := d.Explains(); len(explains) != 4 || explains[3] != "EXPLAIN QUERY PLAN select 2 from v" {
+		t.Fatalf("expected fast reads not to be explained, got %q", explains)
+	}
+}
+
+func TestExplainFullScan(t *testing.T) {
+	mysql := newExplainer(nil, "mysql", nil, time.Second)
+	if !mysql.fullScan(`{"query_block": {"table": {"table_name": "t", "access_type": "ALL"}}}`) ||
+		mysql.fullScan(`{"query_block": {"table": {"table_name": "t", "access_type": "ref"}}}`) {
+		t.Fatal("unexpected MySQL full scan detection")
+	}
+	postgres := newExplainer(nil, "postgres", nil, time.Second)
+	if !postgres.fullScan(`[{"Plan": {"Node Type": "Hash Join", "Plans": [{"Node Type": "Seq Scan"}]}}]`) ||
+		postgres.fullScan(`[{"Plan": {"Node Type": "Index Scan"}}]`) {
+		t.Fatal("unexpected PostgreSQL full scan detection")
+	}
+	sqlite := newExplainer(nil, "sqlite3", nil, time.Second)
+	if sqlite.fullScan("SEARCH t USING INDEX i (a=?)\nSCAN u USING COVERING INDEX j") || !sqlite.fullScan("SCAN t") {
+		t.Fatal("unexpected SQLite full scan detection")
+	}
+	if newExplainer(nil, "sqlserver", nil, time.Second) != nil {
+		t.Fatal("expected no EXPLAIN for SQL Server")
+	}
+}
@@ -0,0 +1,52 @@
+package sqlxcluster
+
+import (
+	"errors"
+	"math/rand"
+)
+
+type Level int
+
+const (
+	LevelInfo Level = iota
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+var errSampleRate = errors.New("sqlxcluster: sample rate out of [0, 1]")
+
+// validSampleRate rejects NaN too.
+func validSampleRate(rate float64) bool {
+	return rate >= 0 && rate <= 1
+}
+
+// allow classifies e and reports whether it should be logged.
+func (st *logState) allow(e *QueryEvent) bool {
+	switch {
+	case e.Failed():
+		e.Level = LevelError
+	case st.slow > 0 && e.Duration >= st.slow, st.longTx > 0 && e.TxDuration >= st.longTx:
+		e.Level = LevelWarn
+		e.Slow = true
+	}
+	if st.slow <= 0 && st.rate <= 0 {
+		return true
+	}
+	if e.Level > LevelInfo {
+		return true
+	}
+	return st.rate > 0 && (st.rate >= 1 || rand.Float64() < st.rate)
+}
@@ -0,0 +1,257 @@
+// Package fakedriver is the database/sql driver the tests of sqlxcluster and
+// of its modules run against.
+package fakedriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var drivers int32
+
+// Driver answers "select N" with N rows of a single column n, fails any
+// statement starting with "fail" and records everything else it executes.
+// "EXPLAIN QUERY PLAN" gets a full scan plan, as SQLite would describe it,
+// unless the query explained is about a table named noplan.
+// Data source names starting with "bad" fail to connect.
+type Driver struct {
+	Name     string
+	mutex    sync.Mutex
+	opened   []string
+	execs    []string
+	explains []string
+	prepared []string
+}
+
+// New returns a Driver registered under a name of its own.
+func New() *Driver {
+	d := &Driver{Name: fmt.Sprintf("fakedriver%d", atomic.AddInt32(&drivers, 1))}
+	sql.Register(d.Name, d)
+	return d
+}
+
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	if strings.HasPrefix(dsn, "bad") {
+		return nil, fmt.Errorf("fake: access denied for %s", dsn)
+	}
+	d.mutex.Lock()
+	d.opened = append(d.opened, dsn)
+	d.mutex.Unlock()
+	return &conn{d: d, dsn: dsn}, nil
+}
+
+// Connector returns a connector opening dsn.
+func (d *Driver) Connector(dsn string) driver.Connector {
+	return &connector{d: d, dsn: dsn}
+}
+
+// Opened returns the data source names connected to, in order.
+func (d *Driver) Opened() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]string(nil), d.opened...)
+}
+
+// Explains returns the EXPLAIN statements run.
+func (d *Driver) Explains() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]string(nil), d.explains...)
+}
+
+// Prepared returns the statements prepared.
+func (d *Driver) Prepared() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]string(nil), d.prepared...)
+}
+
+// Execs returns the statements executed successfully.
+func (d *Driver) Execs() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]string(nil), d.execs...)
+}
+
+// ContextDriver is a Driver implementing driver.DriverContext.
+type ContextDriver struct {
+	*Driver
+	connectors []string
+}
+
+// Context returns d as a driver.DriverContext.
+func (d *Driver) Context() *ContextDriver {
+	return &ContextDriver{Driver: d}
+}
+
+func (d *ContextDriver) OpenConnector(dsn string) (driver.Connector, error) {
+	d.mutex.Lock()
+	d.connectors = append(d.connectors, dsn)
+	d.mutex.Unlock()
+	return &connector{d: d.Driver, dsn: dsn}, nil
+}
+
+// Connectors returns the data source names connectors were opened for, in
+// order.
+func (d *ContextDriver) Connectors() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]string(nil), d.connectors...)
+}
+
+type connector struct {
+	d   *Driver
+	dsn string
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.d.Open(c.dsn)
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.d
+}
+
+type conn struct {
+	d   *Driver
+	dsn string
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	if strings.HasPrefix(query, "fail") {
+		return nil, fmt.Errorf("fake: syntax error near %q", query)
+	}
+	c.d.mutex.Lock()
+	c.d.prepared = append(c.d.prepared, query)
+	c.d.mutex.Unlock()
+	return &stmt{c: c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c, nil
+}
+
+func (c *conn) Commit() error {
+	return nil
+}
+
+func (c *conn) Rollback() error {
+	return nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if strings.HasPrefix(query, "fail") {
+		return nil, fmt.Errorf("fake: syntax error near %q", query)
+	}
+	c.d.mutex.Lock()
+	c.d.execs = append(c.d.execs, query)
+	n := int64(len(c.d.execs))
+	c.d.mutex.Unlock()
+	return result(n), nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if strings.HasPrefix(query, "fail") {
+		return nil, fmt.Errorf("fake: syntax error near %q", query)
+	}
+	if strings.HasPrefix(query, "EXPLAIN QUERY PLAN ") {
+		c.d.mutex.Lock()
+		c.d.explains = append(c.d.explains, query)
+		c.d.mutex.Unlock()
+		if strings.Contains(query, " noplan") {
+			return nil, errors.New("fake: no plan")
+		}
+		return &planRows{}, nil
+	}
+	var n int
+	if fields := strings.Fields(query); len(fields) > 1 {
+		n, _ = strconv.Atoi(fields[1])
+	}
+	return &rows{n: n}, nil
+}
+
+type stmt struct {
+	c     *conn
+	query string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.c.ExecContext(context.Background(), s.query, nil)
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.c.QueryContext(context.Background(), s.query, nil)
+}
+
+type result int64
+
+func (r result) LastInsertId() (int64, error) {
+	return int64(r), nil
+}
+
+func (r result) RowsAffected() (int64, error) {
+	return 1, nil
+}
+
+type rows struct {
+	n int
+	i int
+}
+
+func (r *rows) Columns() []string {
+	return []string{"n"}
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.i >= r.n {
+		return io.EOF
+	}
+	r.i++
+	dest[0] = int64(r.i)
+	return nil
+}
+
+type planRows struct {
+	done bool
+}
+
+func (r *planRows) Columns() []string {
+	return []string{"id", "parent", "notused", "detail"}
+}
+
+func (r *planRows) Close() error {
+	return nil
+}
+
+func (r *planRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0], dest[1], dest[2], dest[3] = int64(2), int64(0), int64(0), "SCAN t"
+	return nil
+}
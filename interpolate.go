@@ -0,0 +1,225 @@
+package sqlxcluster
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type dialect int
+
+const (
+	dialectGeneric dialect = iota
+	dialectMySQL
+	dialectPostgres
+	dialectSQLite
+	dialectSQLServer
+	dialectOracle
+)
+
+func dialectOf(driverName string) dialect {
+	switch {
+	case strings.Contains(driverName, "mysql"):
+		return dialectMySQL
+	case strings.Contains(driverName, "sqlite"):
+		return dialectSQLite
+	case driverName == "sqlserver" || driverName == "mssql":
+		return dialectSQLServer
+	}
+	switch sqlx.BindType(driverName) {
+	case sqlx.DOLLAR:
+		return dialectPostgres
+	case sqlx.NAMED:
+		return dialectOracle
+	case sqlx.AT:
+		return dialectSQLServer
+	}
+	return dialectGeneric
+}
+
+// Interpolate returns query with its placeholders replaced by args written
+// as SQL literals of the dialect of driverName, so that it can be pasted in a
+// database console. It is meant for logs and debugging: never run its result
+// instead of the parameterized query.
+func Interpolate(driverName string, query string, args ...interface{}) (string, error) {
+	return interpolate(dialectOf(driverName), sqlx.BindType(driverName), query, args)
+}
+
+// interpolateNamed inlines the arguments of a query with named parameters.
+func interpolateNamed(driverName string, query string, names []string, args []interface{}) (string, error) {
+	named := make([]interface{}, len(args))
+	for i, arg := range args {
+		named[i] = sql.Named(names[i], arg)
+	}
+	return interpolate(dialectOf(driverName), sqlx.NAMED, query, named)
+}
+
+func interpolate(d dialect, bindType int, query string, args []interface{}) (string, error) {
+	var b strings.Builder
+	seq := 0
+	for _, t := range lexSQL(d, query) {
+		if t.kind != tokPlaceholder || !bindsPlaceholder(bindType, t.text) {
+			b.WriteString(t.text)
+			continue
+		}
+		arg, ok := placeholderArg(t.text, seq, args)
+		seq++
+		if !ok {
+			return "", fmt.Errorf("sqlxcluster: missing argument for placeholder %s", t.text)
+		}
+		literal, err := formatLiteral(d, arg)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(literal)
+	}
+	return b.String(), nil
+}
+
+func bindsPlaceholder(bindType int, text string) bool {
+	switch bindType {
+	case sqlx.QUESTION:
+		return text == "?"
+	case sqlx.DOLLAR:
+		return text[0] == '$'
+	case sqlx.NAMED:
+		return text[0] == ':'
+	case sqlx.AT:
+		return text[0] == '@'
+	}
+	return true
+}
+
+func placeholderArg(text string, seq int, args []interface{}) (interface{}, bool) {
+	if text[0] == ':' || text[0] == '@' {
+		for _, arg := range args {
+			if named, ok := arg.(sql.NamedArg); ok && named.Name == text[1:] {
+				return named.Value, true
+			}
+		}
+	}
+	i := placeholderIndex(text, seq)
+	if i < 0 || i >= len(args) {
+		return nil, false
+	}
+	if named, ok := args[i].(sql.NamedArg); ok {
+		return named.Value, true
+	}
+	return args[i], true
+}
+
+func formatLiteral(d dialect, arg interface{}) (string, error) {
+	if _, ok := arg.(Secret); ok {
+		return quoteString(d, defaultMask), nil
+	}
+	if v, ok := arg.(driver.Valuer); ok {
+		value, err := v.Value()
+		if err != nil {
+			return "", err
+		}
+		arg = value
+	}
+	switch v := arg.(type) {
+	case nil:
+		return "NULL", nil
+	case string:
+		return quoteString(d, v), nil
+	case []byte:
+		if v == nil {
+			return "NULL", nil
+		}
+		return quoteBytes(d, v), nil
+	case bool:
+		switch d {
+		case dialectSQLServer, dialectOracle:
+			if v {
+				return "1", nil
+			}
+			return "0", nil
+		}
+		if v {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case time.Time:
+		return quoteTime(d, v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return quoteString(d, strconv.FormatFloat(v, 'g', -1, 64)), nil
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case int, int8, int16, int32, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprintf("%d", v), nil
+	case float32:
+		return formatLiteral(d, float64(v))
+	}
+	value, err := driver.DefaultParameterConverter.ConvertValue(arg)
+	if err != nil {
+		return "", fmt.Errorf("sqlxcluster: cannot interpolate %T: %v", arg, err)
+	}
+	return formatLiteral(d, value)
+}
+
+func quoteString(d dialect, s string) string {
+	var b strings.Builder
+	if d == dialectSQLServer && !isASCII(s) {
+		b.WriteByte('N')
+	}
+	b.WriteByte('\'')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\'':
+			b.WriteString("''")
+		case c == '\\' && d == dialectMySQL:
+			b.WriteString(`\\`)
+		case c == 0 && d == dialectMySQL:
+			b.WriteString(`\0`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+func quoteBytes(d dialect, p []byte) string {
+	h := hex.EncodeToString(p)
+	switch d {
+	case dialectPostgres:
+		return `'\x` + h + `'::bytea`
+	case dialectSQLServer:
+		return "0x" + h
+	case dialectOracle:
+		return "HEXTORAW('" + h + "')"
+	}
+	return "X'" + h + "'"
+}
+
+func quoteTime(d dialect, t time.Time) string {
+	switch d {
+	case dialectPostgres:
+		return "'" + t.Format("2006-01-02 15:04:05.999999-07:00") + "'"
+	case dialectOracle:
+		return "TIMESTAMP '" + t.Format("2006-01-02 15:04:05.999999") + "'"
+	}
+	return "'" + t.Format("2006-01-02 15:04:05.999999") + "'"
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
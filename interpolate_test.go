@@ -0,0 +1,43 @@
+package sqlxcluster
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestInterpolate(t *testing.T) {
+	at := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	tests := []struct {
+		driver string
+		query  string
+		args   []interface{}
+		want   string
+	}{
+		{"mysql", "select * from t where id = ? and name = ? and note = '?'", []interface{}{42, `O'Re\illy`}, `select * from t where id = 42 and name = 'O''Re\\illy' and note = '?'`},
+		{"mysql", "insert into t values (?, ?, ?, ?)", []interface{}{nil, []byte{0xca, 0xfe}, true, at}, "insert into t values (NULL, X'cafe', TRUE, '2024-05-06 07:08:09')"},
+		{"postgres", "select * from t where a = $2 and b = $1 and c ? 'k'", []interface{}{"x", 1.5}, "select * from t where a = 1.5 and b = 'x' and c ? 'k'"},
+		{"postgres", "select $1::bytea, $2", []interface{}{[]byte("a"), at}, `select '\x61'::bytea::bytea, '2024-05-06 07:08:09+00:00'`},
+		{"sqlserver", "select * from t where a = @p1 and b = @name", []interface{}{false, sql.Named("name", "é")}, "select * from t where a = 0 and b = N'é'"},
+		{"sqlite3", "select ?", []interface{}{sql.NullString{}}, "select NULL"},
+		{"mysql", "select ?", []interface{}{Secret{V: "hunter2"}}, "select '***'"},
+		{"mysql", `select '\'', ?`, []interface{}{"x"}, `select '\'', 'x'`},
+		{"postgres", `select '\' || $1`, []interface{}{"x"}, `select '\' || 'x'`},
+		{"postgres", `select E'\'', $1`, []interface{}{"x"}, `select E'\'', 'x'`},
+		{"sqlite3", `select '\', ?`, []interface{}{"x"}, `select '\', 'x'`},
+	}
+	for _, tt := range tests {
+		got, err := Interpolate(tt.driver, tt.query, tt.args...)
+		if err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.query, got, tt.want)
+		}
+	}
+
+	if _, err := Interpolate("mysql", "select ?, ?", 1); err == nil {
+		t.Error("expected error for a missing argument")
+	}
+}
@@ -0,0 +1,189 @@
+package sqlxcluster
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokSpace tokenKind = iota
+	tokComment
+	tokIdent
+	tokQuotedIdent
+	tokString
+	tokNumber
+	tokPlaceholder
+	tokOperator
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// lexSQL splits query into tokens whose texts concatenate back to query.
+func lexSQL(d dialect, query string) []token {
+	var tokens []token
+	for i := 0; i < len(query); {
+		kind, n := lexToken(d, query[i:])
+		tokens = append(tokens, token{kind: kind, text: query[i : i+n]})
+		i += n
+	}
+	return tokens
+}
+
+func lexToken(d dialect, s string) (tokenKind, int) {
+	c := s[0]
+	switch {
+	case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+		n := 1
+		for n < len(s) && (s[n] == ' ' || s[n] == '\t' || s[n] == '\n' || s[n] == '\r') {
+			n++
+		}
+		return tokSpace, n
+	case strings.HasPrefix(s, "--") || c == '#' && d == dialectMySQL:
+		n := strings.IndexByte(s, '\n')
+		if n < 0 {
+			n = len(s)
+		}
+		return tokComment, n
+	case strings.HasPrefix(s, "/*"):
+		n := strings.Index(s[2:], "*/")
+		if n < 0 {
+			return tokComment, len(s)
+		}
+		return tokComment, n + 4
+	case c == '\'':
+		return tokString, lexQuoted(d, s, '\'')
+	case (c == 'E' || c == 'e') && d == dialectPostgres && len(s) > 1 && s[1] == '\'':
+		return tokString, lexQuoted(d, s, '\'')
+	case c == '"' || c == '`':
+		return tokQuotedIdent, lexQuoted(d, s, c)
+	case c == '[':
+		n := strings.IndexByte(s, ']')
+		if n < 0 {
+			return tokPunct, 1
+		}
+		return tokQuotedIdent, n + 1
+	case c >= '0' && c <= '9' || c == '.' && len(s) > 1 && s[1] >= '0' && s[1] <= '9':
+		return tokNumber, lexNumber(s)
+	case c == '?':
+		return tokPlaceholder, 1
+	case c == '$' && len(s) > 1 && s[1] >= '0' && s[1] <= '9':
+		n := 1
+		for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+			n++
+		}
+		return tokPlaceholder, n
+	case (c == ':' || c == '@') && len(s) > 1 && isIdentStart(s[1:]):
+		return tokPlaceholder, 1 + lexIdent(s[1:])
+	case c == ':' && len(s) > 1 && s[1] == ':':
+		return tokOperator, 2
+	case isIdentStart(s):
+		return tokIdent, lexIdent(s)
+	case strings.IndexByte("(),;.", c) >= 0:
+		return tokPunct, 1
+	case strings.IndexByte("<>=!|&+-*/%^~:#", c) >= 0:
+		n := 1
+		for n < len(s) && strings.IndexByte("<>=!|&", s[n]) >= 0 {
+			n++
+		}
+		return tokOperator, n
+	}
+	_, n := utf8.DecodeRuneInString(s)
+	return tokPunct, n
+}
+
+// lexQuoted returns the length of the quoted text s starts with.
+func lexQuoted(d dialect, s string, quote byte) int {
+	start := strings.IndexByte(s, quote)
+	escape := start > 0 || d == dialectMySQL && quote != '`'
+	for i := start + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if escape {
+				i++
+			}
+		case quote:
+			if i+1 < len(s) && s[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+func lexNumber(s string) int {
+	n := 0
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		n = 2
+		for n < len(s) && strings.IndexByte("0123456789abcdefABCDEF", s[n]) >= 0 {
+			n++
+		}
+		return n
+	}
+	for n < len(s) && (s[n] >= '0' && s[n] <= '9' || s[n] == '.') {
+		n++
+	}
+	if n < len(s) && (s[n] == 'e' || s[n] == 'E') {
+		m := n + 1
+		if m < len(s) && (s[m] == '+' || s[m] == '-') {
+			m++
+		}
+		if m < len(s) && s[m] >= '0' && s[m] <= '9' {
+			n = m
+			for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func isIdentStart(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func lexIdent(s string) int {
+	n := 0
+	for n < len(s) {
+		r, size := utf8.DecodeRuneInString(s[n:])
+		if r != '_' && r != '$' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		n += size
+	}
+	return n
+}
+
+// significant drops spaces and comments.
+func significant(tokens []token) []token {
+	var ls []token
+	for _, t := range tokens {
+		if t.kind != tokSpace && t.kind != tokComment {
+			ls = append(ls, t)
+		}
+	}
+	return ls
+}
+
+func (t token) is(keyword string) bool {
+	return t.kind == tokIdent && strings.EqualFold(t.text, keyword)
+}
+
+func unquoteIdent(s string) string {
+	if len(s) >= 2 {
+		switch s[0] {
+		case '"', '`', '[':
+			return s[1 : len(s)-1]
+		}
+	}
+	return s
+}
@@ -0,0 +1,250 @@
+package sqlxcluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LogConfig is the logging configuration of a ClusterDB, see SetLogConfig.
+type LogConfig struct {
+	Enable          bool
+	Color           bool
+	Out             func(b []byte) (int, error) // the standard error when nil
+	Logger          QueryLogger                 // the text format written to Out when nil
+	Level           Level                       // least level logged
+	SlowThreshold   time.Duration
+	SampleRate      float64
+	LongTxThreshold time.Duration
+}
+
+func (conf *LogConfig) validate() error {
+	return Validate(WithSlowThreshold(conf.SlowThreshold), WithSampleRate(conf.SampleRate),
+		WithLongTxThreshold(conf.LongTxThreshold))
+}
+
+// logSwitch holds the logging configuration shared by the nodes of a cluster.
+type logSwitch struct {
+	mutex sync.Mutex   // serializes the writers
+	state atomic.Value // *logState
+}
+
+type logState struct {
+	enable bool
+	color  bool
+	out    func(b []byte) (int, error)
+	custom QueryLogger
+	logger QueryLogger // custom, or the text format written to out
+	level  Level
+	slow   time.Duration
+	rate   float64
+	longTx time.Duration
+}
+
+func newLogSwitch(os *options) *logSwitch {
+	sw := &logSwitch{}
+	sw.store(logState{enable: os.enableLog, color: os.color, out: os.out, custom: os.logger, level: os.level,
+		slow: os.slowThreshold, rate: os.sampleRate, longTx: os.longTxThreshold})
+	return sw
+}
+
+func (sw *logSwitch) load() *logState {
+	return sw.state.Load().(*logState)
+}
+
+// store swaps in st, given its output and logger.
+func (sw *logSwitch) store(st logState) {
+	if st.out == nil {
+		st.out = defaultOut
+	}
+	st.logger = st.custom
+	if st.logger == nil {
+		st.logger = NewTextLogger(st.out, WithColor(st.color))
+	}
+	sw.state.Store(&st)
+}
+
+// update swaps the state for f applied to a copy of it.
+func (sw *logSwitch) update(f func(st *logState)) {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+	st := *sw.load()
+	f(&st)
+	sw.store(st)
+}
+
+// LogConfig returns the logging configuration in use.
+func (c *ClusterDB) LogConfig() LogConfig {
+	return c.log.load().config()
+}
+
+func (st *logState) config() LogConfig {
+	return LogConfig{
+		Enable:          st.enable,
+		Color:           st.color,
+		Out:             st.out,
+		Logger:          st.custom,
+		Level:           st.level,
+		SlowThreshold:   st.slow,
+		SampleRate:      st.rate,
+		LongTxThreshold: st.longTx,
+	}
+}
+
+// SetLogConfig changes the logging configuration of every node. It is safe
+// to call while queries run, which are logged with either configuration.
+func (c *ClusterDB) SetLogConfig(conf LogConfig) error {
+	return c.UpdateLogConfig(func(cur *LogConfig) error {
+		*cur = conf
+		return nil
+	})
+}
+
+// UpdateLogConfig changes the logging configuration of every node to the one
+// f makes of it, unless f fails. Concurrent updates are applied one after
+// the other, so that none is lost.
+func (c *ClusterDB) UpdateLogConfig(f func(conf *LogConfig) error) (err error) {
+	c.log.update(func(st *logState) {
+		conf := st.config()
+		if err = f(&conf); err == nil {
+			err = conf.validate()
+		}
+		if err == nil {
+			*st = logState{enable: conf.Enable, color: conf.Color, out: conf.Out, custom: conf.Logger, level: conf.Level,
+				slow: conf.SlowThreshold, rate: conf.SampleRate, longTx: conf.LongTxThreshold}
+		}
+	})
+	return err
+}
+
+// LogConfigurable is implemented by ClusterDB.
+type LogConfigurable interface {
+	LogConfig() LogConfig
+	SetLogConfig(conf LogConfig) error
+	UpdateLogConfig(f func(conf *LogConfig) error) error
+}
+
+var _ LogConfigurable = (*ClusterDB)(nil)
+
+// logConfigJSON is the body of LogConfigHandler.
+type logConfigJSON struct {
+	Enable          *bool    `json:"enable,omitempty"`
+	Color           *bool    `json:"color,omitempty"`
+	Level           string   `json:"level,omitempty"`
+	SlowThreshold   string   `json:"slow_threshold,omitempty"`
+	SampleRate      *float64 `json:"sample_rate,omitempty"`
+	LongTxThreshold string   `json:"long_tx_threshold,omitempty"`
+}
+
+func (j *logConfigJSON) apply(conf *LogConfig) error {
+	if j.Enable != nil {
+		conf.Enable = *j.Enable
+	}
+	if j.Color != nil {
+		conf.Color = *j.Color
+	}
+	if j.Level != "" {
+		level, err := ParseLevel(j.Level)
+		if err != nil {
+			return err
+		}
+		conf.Level = level
+	}
+	for _, d := range []struct {
+		s string
+		d *time.Duration
+	}{{j.SlowThreshold, &conf.SlowThreshold}, {j.LongTxThreshold, &conf.LongTxThreshold}} {
+		if d.s == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.s)
+		if err != nil {
+			return err
+		}
+		*d.d = v
+	}
+	if j.SampleRate != nil {
+		conf.SampleRate = *j.SampleRate
+	}
+	return nil
+}
+
+// LogConfigHandler serves the logging configuration of db as JSON on GET and
+// changes it with the fields of the JSON body of PUT, PATCH and POST, such as
+// {"enable": true, "slow_threshold": "200ms"}. The output and the logger cannot
+// be changed this way.
+func LogConfigHandler(db LogConfigurable) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPut, http.MethodPatch, http.MethodPost:
+			var j logConfigJSON
+			if err := json.NewDecoder(r.Body).Decode(&j); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := db.UpdateLogConfig(j.apply); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT, PATCH, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		conf := db.LogConfig()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&logConfigJSON{
+			Enable:          &conf.Enable,
+			Color:           &conf.Color,
+			Level:           conf.Level.String(),
+			SlowThreshold:   conf.SlowThreshold.String(),
+			SampleRate:      &conf.SampleRate,
+			LongTxThreshold: conf.LongTxThreshold.String(),
+		})
+	})
+}
+
+// HandleLogSignals enables the logs of db on the enable signal and disables
+// them on the disable one, typically syscall.SIGUSR1 and syscall.SIGUSR2.
+// Calling the returned function stops handling them.
+func HandleLogSignals(db LogConfigurable, enable os.Signal, disable os.Signal) (stop func()) {
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, enable, disable)
+	go func() {
+		for {
+			select {
+			case sig := <-ch:
+				db.UpdateLogConfig(func(conf *LogConfig) error {
+					conf.Enable = sig == enable
+					return nil
+				})
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+}
+
+// ParseLevel parses the names returned by Level.String.
+func ParseLevel(s string) (Level, error) {
+	for _, level := range []Level{LevelInfo, LevelWarn, LevelError} {
+		if s == level.String() {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("sqlxcluster: unknown level %q", s)
+}
@@ -0,0 +1,176 @@
+package sqlxcluster
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-comm/sqlxcluster/internal/fakedriver"
+)
+
+func TestSetLogConfigUnderTraffic(t *testing.T) {
+	d := fakedriver.New()
+	var logged int64
+	c := OpenClusterDB(d.Name, d.Connector("primary"), []driver.Connector{d.Connector("replica")},
+		WithLogger(QueryLoggerFunc(func(ctx context.Context, e *QueryEvent) {
+			atomic.AddInt64(&logged, 1)
+		})))
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				c.Exec("update t set a = 1")
+				var n int
+				c.Get(&n, "select 1")
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		c.SetLog(i%2 == 0, false, nil)
+		conf := c.LogConfig()
+		conf.SlowThreshold = time.Duration(i)
+		c.SetLogConfig(conf)
+	}
+	close(stop)
+	wg.Wait()
+
+	c.SetLog(false, false, nil)
+	n := atomic.LoadInt64(&logged)
+	c.Exec("update t set a = 2")
+	if atomic.LoadInt64(&logged) != n || c.Logged() {
+		t.Fatal("expected logging to be disabled")
+	}
+}
+
+func TestLogConfigLevel(t *testing.T) {
+	d := fakedriver.New()
+	var events []*QueryEvent
+	c := OpenClusterDB(d.Name, d.Connector("primary"), nil, WithEnableLog(true), WithLogLevel(LevelWarn),
+		WithLogger(QueryLoggerFunc(func(ctx context.Context, e *QueryEvent) {
+			events = append(events, e)
+		})))
+	defer c.Close()
+
+	c.Exec("update t set a = 1")
+	c.Exec("fail")
+	if len(events) != 1 || events[0].Level != LevelError {
+		t.Fatalf("expected only the failure logged, got %d events", len(events))
+	}
+
+	conf := c.LogConfig()
+	conf.Level = LevelInfo
+	if err := c.SetLogConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+	c.Exec("update t set a = 2")
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	conf.SampleRate = 2
+	if c.SetLogConfig(conf) == nil {
+		t.Fatal("expected an invalid sample rate to be refused")
+	}
+	conf.SampleRate = 0
+	for _, d := range []*time.Duration{&conf.SlowThreshold, &conf.LongTxThreshold} {
+		*d = -time.Second
+		if c.SetLogConfig(conf) == nil {
+			t.Fatal("expected a negative threshold to be refused")
+		}
+		*d = 0
+	}
+	if c.LogConfig().SlowThreshold != 0 || c.LogConfig().LongTxThreshold != 0 {
+		t.Fatal("expected the refused configurations to be left out")
+	}
+}
+
+func TestUpdateLogConfig(t *testing.T) {
+	d := fakedriver.New()
+	c := OpenClusterDB(d.Name, d.Connector("primary"), nil)
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			c.UpdateLogConfig(func(conf *LogConfig) error {
+				conf.SlowThreshold++
+				return nil
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			c.SetLog(true, false, nil)
+		}()
+	}
+	wg.Wait()
+	if conf := c.LogConfig(); conf.SlowThreshold != 50 || !conf.Enable {
+		t.Fatalf("expected no update to be lost, got %+v", conf)
+	}
+}
+
+func TestLogConfigHandler(t *testing.T) {
+	d := fakedriver.New()
+	c := OpenClusterDB(d.Name, d.Connector("primary"), nil)
+	defer c.Close()
+	h := LogConfigHandler(c)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(`{"enable": true, "level": "warn", "slow_threshold": "200ms"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", w.Code, w.Body)
+	}
+	conf := c.LogConfig()
+	if !conf.Enable || conf.Level != LevelWarn || conf.SlowThreshold != 200*time.Millisecond || conf.Out == nil {
+		t.Fatalf("unexpected config %+v", conf)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	want := `{"enable":true,"color":false,"level":"warn","slow_threshold":"200ms","sample_rate":0,"long_tx_threshold":"0s"}`
+	if strings.TrimSpace(w.Body.String()) != want {
+		t.Fatalf("unexpected body %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"level": "debug"}`)))
+	if w.Code != http.StatusBadRequest || c.LogConfig().Level != LevelWarn {
+		t.Fatalf("expected the update to be refused, got %d", w.Code)
+	}
+}
+
+func TestIdleChain(t *testing.T) {
+	d := fakedriver.New()
+	c := OpenClusterDB(d.Name, d.Connector("primary"), nil)
+	defer c.Close()
+	ch := c.DB.(interface{ nodeChain() *chain }).nodeChain()
+	if !ch.idle() {
+		t.Fatal("expected the chain to be idle with logging disabled")
+	}
+	c.SetLog(true, false, func(b []byte) (int, error) { return len(b), nil })
+	if ch.idle() {
+		t.Fatal("expected the chain to run with logging enabled")
+	}
+
+	c = OpenClusterDB(d.Name, d.Connector("primary"), nil, WithQueryStats(true))
+	defer c.Close()
+	if c.DB.(interface{ nodeChain() *chain }).nodeChain().idle() {
+		t.Fatal("expected the chain to run with an interceptor")
+	}
+}
@@ -0,0 +1,35 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package sqlxcluster
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-comm/sqlxcluster/internal/fakedriver"
+)
+
+func TestHandleLogSignals(t *testing.T) {
+	d := fakedriver.New()
+	c := OpenClusterDB(d.Name, d.Connector("primary"), nil)
+	defer c.Close()
+	stop := HandleLogSignals(c, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer stop()
+
+	for _, enable := range []bool{true, false} {
+		sig := syscall.SIGUSR1
+		if !enable {
+			sig = syscall.SIGUSR2
+		}
+		syscall.Kill(syscall.Getpid(), sig)
+		deadline := time.Now().Add(time.Second)
+		for c.Logged() != enable && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if c.Logged() != enable {
+			t.Fatalf("expected logging enabled %v after %s", enable, sig)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package sqlxcluster
 
 import (
-	"database/sql"
 	"fmt"
 	"strings"
 	"testing"
@@ -11,7 +10,7 @@ type testDB struct {
 	DB
 }
 
-func (db *testDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+func (db *testDB) Query(query string, args ...interface{}) (*Rows, error) {
 	if strings.Index(query, "select") == 0 {
 		return nil, nil
 	}
@@ -20,7 +19,7 @@ func (db *testDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
 
 func TestLoggedQuery(t *testing.T) {
 	var db DB = &testDB{}
-	db = NewLoggedDB(db, false, nil)
+	db = NewLoggedDB(db)
 
 	db.Query("select")
 	db.Query("wrong")
@@ -0,0 +1,474 @@
+package sqlxcluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type Op string
+
+const (
+	OpExec         Op = "exec"
+	OpQuery        Op = "query"
+	OpQueryRow     Op = "query_row"
+	OpPrepare      Op = "prepare"
+	OpGet          Op = "get"
+	OpSelect       Op = "select"
+	OpNamedExec    Op = "named_exec"
+	OpNamedQuery   Op = "named_query"
+	OpPrepareNamed Op = "prepare_named"
+	OpBegin        Op = "begin"
+	OpCommit       Op = "commit"
+	OpRollback     Op = "rollback"
+)
+
+// QueryEvent describes one statement run through a logged or chained DB or
+// Tx, and is what interceptors see and may change.
+type QueryEvent struct {
+	Time         time.Time
+	Op           Op
+	Query        string
+	Args         []interface{}
+	Arg          interface{} // struct or map bound by named statements
+	ArgNames     []string    // parameter names of Args for named statements
+	Statement    string      // Query with Args inlined, see WithInterpolate
+	Duration     time.Duration
+	Err          error
+	Result       sql.Result // result of exec statements
+	Level        Level
+	Slow         bool  // took longer than the slow query or long transaction threshold
+	RowsAffected int64 // -1 when unknown
+	LastInsertID int64 // -1 when unknown
+	Rows         int64 // rows read from a result set, -1 when unknown
+	Cluster      string
+	Node         string
+	Driver       string
+	Role         Role
+	TxID         string
+	TxDuration   time.Duration // time since begin, on commit and rollback
+	Statements   int64         // statements run in the transaction, on commit and rollback
+	Plan         string        // EXPLAIN output of slow reads, see WithExplain
+	FullScan     bool          // Plan reads a whole table
+	Caller       Caller
+
+	explain *explainPlan // running for this event, see WithExplain
+}
+
+// Failed reports whether the statement failed. sql.ErrNoRows is not a failure.
+func (e *QueryEvent) Failed() bool {
+	return e.Err != nil && e.Err != sql.ErrNoRows
+}
+
+type QueryLogger interface {
+	LogQuery(ctx context.Context, e *QueryEvent)
+}
+
+type QueryLoggerFunc func(ctx context.Context, e *QueryEvent)
+
+func (f QueryLoggerFunc) LogQuery(ctx context.Context, e *QueryEvent) {
+	f(ctx, e)
+}
+
+var (
+	_ QueryLogger = QueryLoggerFunc(nil)
+	_ QueryLogger = (*textLogger)(nil)
+	_ QueryLogger = (*jsonLogger)(nil)
+)
+
+// NewTextLogger writes the human readable format used by SetLog, colored when
+// WithColor is set. A nil out writes to the standard error.
+func NewTextLogger(out func(b []byte) (int, error), opts ...Option) QueryLogger {
+	os := newOptions(opts)
+	if out == nil {
+		out = defaultOut
+	}
+	return &textLogger{out: out, color: os.color}
+}
+
+// NewStdLogger writes the uncolored text format to l.
+func NewStdLogger(l *log.Logger) QueryLogger {
+	return &textLogger{out: func(b []byte) (int, error) {
+		l.Print(string(b))
+		return len(b), nil
+	}}
+}
+
+type textLogger struct {
+	out   func(b []byte) (int, error)
+	color bool
+}
+
+func (l *textLogger) LogQuery(ctx context.Context, e *QueryEvent) {
+	// 2009/01/23 01:23:23 /a/b/c/d.go:23: error
+	// [OK] [200ms] select * from user
+
+	var b = bytes.NewBuffer(nil)
+	enableColor := l.color
+
+	if e.Caller.File != "" {
+		b.WriteString(e.Caller.String())
+		b.WriteString(": ")
+	}
+	if e.Failed() {
+		writeColorBytes(b, enableColor, colorRed, []byte(e.Err.Error()))
+	}
+
+	b.WriteString("\r\n")
+	if !e.Failed() {
+		writeColorBytes(b, enableColor, colorGreen, []byte("[OK]"))
+	} else {
+		writeColorBytes(b, enableColor, colorRed, []byte("[FAIL]"))
+	}
+
+	elapsed := e.Duration / time.Millisecond * time.Millisecond
+	writeColorBytes(b, enableColor, colorYellow, []byte(" ["+elapsed.String()+"]"))
+	if e.Slow {
+		writeColorBytes(b, enableColor, colorRed, []byte(" [SLOW]"))
+	}
+	if e.FullScan {
+		writeColorBytes(b, enableColor, colorRed, []byte(" [FULL SCAN]"))
+	}
+	b.WriteString(" ")
+	if e.Statement != "" {
+		writeColorBytes(b, enableColor, colorPurple, []byte(e.Statement))
+	} else {
+		writeColorBytes(b, enableColor, colorPurple, []byte(e.Query))
+	}
+
+	if args := e.Args; len(args) > 0 && e.Statement == "" {
+		b.WriteString("  [")
+		for i, arg := range args {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			if len(e.ArgNames) == len(args) {
+				b.WriteString(e.ArgNames[i])
+				b.WriteString("=")
+			}
+			b.WriteString(fmt.Sprintf("%v", arg))
+		}
+		b.WriteString("]")
+	}
+
+	if e.Rows >= 0 {
+		b.WriteString("  [rows: ")
+		b.WriteString(strconv.FormatInt(e.Rows, 10))
+		b.WriteString("]")
+	}
+
+	if e.RowsAffected >= 0 {
+		b.WriteString("  [rows affected: ")
+		b.WriteString(strconv.FormatInt(e.RowsAffected, 10))
+		if e.LastInsertID > 0 {
+			b.WriteString(", last insert id: ")
+			b.WriteString(strconv.FormatInt(e.LastInsertID, 10))
+		}
+		b.WriteString("]")
+	}
+
+	if e.TxID != "" {
+		b.WriteString("  [tx: ")
+		b.WriteString(e.TxID)
+		if e.Op == OpCommit || e.Op == OpRollback {
+			b.WriteString(", statements: ")
+			b.WriteString(strconv.FormatInt(e.Statements, 10))
+			b.WriteString(", total: ")
+			b.WriteString((e.TxDuration / time.Millisecond * time.Millisecond).String())
+		}
+		b.WriteString("]")
+	}
+
+	if e.Plan != "" {
+		b.WriteString("\r\n")
+		b.WriteString(e.Plan)
+	}
+
+	l.out(b.Bytes())
+}
+
+// NewJSONLogger writes one JSON object per event to w.
+func NewJSONLogger(w io.Writer) QueryLogger {
+	return &jsonLogger{w: w}
+}
+
+type jsonLogger struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+type jsonEvent struct {
+	Time         time.Time     `json:"time"`
+	Op           Op            `json:"op"`
+	Query        string        `json:"query"`
+	Statement    string        `json:"statement,omitempty"`
+	Args         []interface{} `json:"args,omitempty"`
+	DurationMs   float64       `json:"duration_ms"`
+	Error        string        `json:"error,omitempty"`
+	Level        string        `json:"level"`
+	Slow         bool          `json:"slow,omitempty"`
+	ArgNames     []string      `json:"arg_names,omitempty"`
+	RowsAffected *int64        `json:"rows_affected,omitempty"`
+	LastInsertID *int64        `json:"last_insert_id,omitempty"`
+	Rows         *int64        `json:"rows,omitempty"`
+	Cluster      string        `json:"cluster,omitempty"`
+	Node         string        `json:"node,omitempty"`
+	Role         string        `json:"role,omitempty"`
+	TxID         string        `json:"tx_id,omitempty"`
+	TxDurationMs float64       `json:"tx_duration_ms,omitempty"`
+	Statements   int64         `json:"statements,omitempty"`
+	Plan         string        `json:"plan,omitempty"`
+	FullScan     bool          `json:"full_scan,omitempty"`
+	Caller       string        `json:"caller,omitempty"`
+	Function     string        `json:"func,omitempty"`
+}
+
+func (l *jsonLogger) LogQuery(ctx context.Context, e *QueryEvent) {
+	je := jsonEvent{
+		Time:       e.Time,
+		Op:         e.Op,
+		Query:      e.Query,
+		Statement:  e.Statement,
+		DurationMs: float64(e.Duration) / float64(time.Millisecond),
+		Level:      e.Level.String(),
+		Slow:       e.Slow,
+		Cluster:    e.Cluster,
+		Node:       e.Node,
+		TxID:       e.TxID,
+		Statements: e.Statements,
+		Plan:       e.Plan,
+		FullScan:   e.FullScan,
+		Caller:     e.Caller.String(),
+		Function:   e.Caller.Function,
+	}
+	for _, arg := range e.Args {
+		je.Args = append(je.Args, jsonArg(arg))
+	}
+	if e.Err != nil {
+		je.Error = e.Err.Error()
+	}
+	if e.RowsAffected >= 0 {
+		n := e.RowsAffected
+		je.RowsAffected = &n
+	}
+	if e.LastInsertID >= 0 {
+		id := e.LastInsertID
+		je.LastInsertID = &id
+	}
+	if e.Rows >= 0 {
+		n := e.Rows
+		je.Rows = &n
+	}
+	if e.TxDuration > 0 {
+		je.TxDurationMs = float64(e.TxDuration) / float64(time.Millisecond)
+	}
+	je.ArgNames = e.ArgNames
+	if e.Node != "" {
+		je.Role = e.Role.String()
+	}
+	b, err := json.Marshal(je)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+	l.mutex.Lock()
+	l.w.Write(b)
+	l.mutex.Unlock()
+}
+
+func jsonArg(arg interface{}) interface{} {
+	switch v := arg.(type) {
+	case nil, bool, string, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, time.Time:
+		return v
+	case []byte:
+		return string(v)
+	case fmt.Stringer:
+		return v.String()
+	}
+	if _, err := json.Marshal(arg); err == nil {
+		return arg
+	}
+	return fmt.Sprintf("%v", arg)
+}
+
+// queryLog is the logging state shared by the logged wrappers of one node.
+type queryLog struct {
+	sw     *logSwitch
+	redact *Redaction
+	inline bool
+	driver string
+	skip   []string
+}
+
+// txLog follows a transaction begun through a chain.
+type txLog struct {
+	id         string
+	begin      time.Time
+	statements int64
+	ended      int32
+}
+
+func newTxLog() *txLog {
+	return &txLog{id: newTxID(), begin: time.Now()}
+}
+
+func newTxID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+func newQueryLog(os *options) *queryLog {
+	l := &queryLog{
+		sw:     os.logSwitch,
+		redact: os.redaction,
+		inline: os.interpolate,
+		driver: os.driverName,
+		skip:   os.callerSkip,
+	}
+	if l.sw == nil {
+		standalone := *os
+		standalone.enableLog = true
+		l.sw = newLogSwitch(&standalone)
+	}
+	return l
+}
+
+func (l *queryLog) SetColor(color bool) {
+	l.sw.update(func(st *logState) {
+		st.color = color
+	})
+}
+
+func (l *queryLog) SetOutput(out func(b []byte) (int, error)) {
+	l.sw.update(func(st *logState) {
+		st.out = out
+	})
+}
+
+func (l *queryLog) Logged() bool {
+	return l.sw.load().enable
+}
+
+func (l *queryLog) Colored() bool {
+	return l.sw.load().color
+}
+
+func (l *queryLog) Output() func(b []byte) (int, error) {
+	return l.sw.load().out
+}
+
+func (l *queryLog) Before(ctx context.Context, e *QueryEvent) (context.Context, error) {
+	return ctx, nil
+}
+
+// After logs e if it passes the filter. Redaction and interpolation are done
+// on a copy, leaving e as the other interceptors see it.
+func (l *queryLog) After(ctx context.Context, e *QueryEvent) {
+	st := l.sw.load()
+	if !st.enable || !st.allow(e) || e.Level < st.level {
+		return
+	}
+	le := *e
+	if le.Arg != nil {
+		le.ArgNames, le.Args = namedArgs(le.Query, le.Arg)
+	}
+	if p := e.explain; p != nil {
+		// The slow read that ran the EXPLAIN is logged with its plan.
+		le.explain = nil
+		go func() {
+			<-p.done
+			le.Plan, le.FullScan = p.plan, p.fullScan
+			l.write(detached{ctx}, st.logger, &le)
+		}()
+		return
+	}
+	l.write(ctx, st.logger, &le)
+}
+
+func setResult(e *QueryEvent, result sql.Result) {
+	if e.Err != nil || result == nil {
+		return
+	}
+	if n, err := result.RowsAffected(); err == nil {
+		e.RowsAffected = n
+	}
+	if id, err := result.LastInsertId(); err == nil {
+		e.LastInsertID = id
+	}
+}
+
+func (l *queryLog) write(ctx context.Context, logger QueryLogger, e *QueryEvent) {
+	if l.redact != nil {
+		e.Args = l.redact.apply(dialectOf(l.driver), e.Query, e.Args, e.ArgNames)
+	}
+	if l.inline && len(e.Args) > 0 {
+		if len(e.ArgNames) == len(e.Args) {
+			e.Statement, _ = interpolateNamed(l.driver, e.Query, e.ArgNames, e.Args)
+		} else {
+			e.Statement, _ = Interpolate(l.driver, e.Query, e.Args...)
+		}
+	}
+	if e.Caller == (Caller{}) {
+		e.Caller = findCaller(l.skip)
+	}
+	logger.LogQuery(ctx, e)
+}
+
+// namedArgs binds arg to the named parameters of query.
+func namedArgs(query string, arg interface{}) ([]string, []interface{}) {
+	if arg == nil {
+		return nil, nil
+	}
+	_, args, err := sqlx.Named(query, arg)
+	if err != nil {
+		return nil, []interface{}{arg}
+	}
+	params := namedParams(query)
+	if len(params) == 0 || len(args)%len(params) != 0 {
+		return nil, args
+	}
+	names := make([]string, len(args))
+	for i := range names {
+		names[i] = params[i%len(params)]
+	}
+	return names, args
+}
+
+// namedParams lists the named parameters of query, following sqlx.
+func namedParams(query string) []string {
+	var names []string
+	rs := []rune(query)
+	for i := 0; i < len(rs); i++ {
+		if rs[i] != ':' {
+			continue
+		}
+		if i+1 < len(rs) && (rs[i+1] == ':' || rs[i+1] == '=') {
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(rs) && (rs[j] == '_' || rs[j] == '.' || unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j])) {
+			j++
+		}
+		if j > i+1 {
+			names = append(names, string(rs[i+1:j]))
+		}
+		i = j - 1
+	}
+	return names
+}
@@ -0,0 +1,251 @@
+package sqlxcluster
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-comm/sqlxcluster/internal/fakedriver"
+)
+
+func TestJSONLogger(t *testing.T) {
+	d := fakedriver.New()
+	var buf bytes.Buffer
+	c := OpenClusterDB(d.Name, d.Connector("primary"), []driver.Connector{d.Connector("replica")},
+		WithName("users"), WithNodeNames("db-1:3306", "db-2:3306"), WithEnableLog(true), WithLogger(NewJSONLogger(&buf)))
+	defer c.Close()
+
+	if _, err := c.Exec("insert into t values (?)", 42); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Query("fail"); err == nil {
+		t.Fatal("expected query failure")
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 events, got %q", buf.String())
+	}
+	var exec, query map[string]interface{}
+	json.Unmarshal([]byte(lines[0]), &exec)
+	json.Unmarshal([]byte(lines[1]), &query)
+	if exec["op"] != "exec" || exec["cluster"] != "users" || exec["node"] != "db-1:3306" || exec["role"] != "primary" || exec["rows_affected"] != 1.0 {
+		t.Fatalf("unexpected exec event: %s", lines[0])
+	}
+	if query["op"] != "query" || query["node"] != "db-2:3306" || query["role"] != "replica" || query["error"] == nil {
+		t.Fatalf("unexpected query event: %s", lines[1])
+	}
+}
+
+func TestSlowQueryFilter(t *testing.T) {
+	d := fakedriver.New()
+	var events []*QueryEvent
+	c := OpenClusterDB(d.Name, d.Connector("primary"), nil, WithEnableLog(true), WithSlowThreshold(time.Hour),
+		WithLogger(QueryLoggerFunc(func(ctx context.Context, e *QueryEvent) {
+			events = append(events, e)
+		})))
+	defer c.Close()
+
+	c.Exec("update t set a = 1")
+	c.Exec("fail")
+	if len(events) != 1 || events[0].Level != LevelError {
+		t.Fatalf("expected only the failure logged, got %d events", len(events))
+	}
+
+	c.SetSlowThreshold(time.Nanosecond)
+	c.Exec("update t set a = 2")
+	if len(events) != 2 || !events[1].Slow || events[1].Level != LevelWarn {
+		t.Fatal("expected a slow query event")
+	}
+
+	c.SetSlowThreshold(time.Hour)
+	if err := c.SetSampleRate(1.5); err == nil {
+		t.Fatal("expected an invalid sample rate")
+	}
+	if err := c.SetSampleRate(1); err != nil {
+		t.Fatal(err)
+	}
+	c.Exec("update t set a = 3")
+	if len(events) != 3 || events[2].Slow {
+		t.Fatal("expected a sampled query event")
+	}
+}
+
+func TestLoggedNamedArgs(t *testing.T) {
+	d := fakedriver.New()
+	var buf bytes.Buffer
+	c := OpenClusterDB(d.Name, d.Connector("primary"), nil, WithEnableLog(true), WithOutput(buf.Write))
+	defer c.Close()
+
+	user := struct {
+		Name  string `db:"name"`
+		Email string `db:"email"`
+	}{"bob", "bob@example.com"}
+	if _, err := c.NamedExec("insert into users (name, email) values (:name, :email)", user); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "[name=bob, email=bob@example.com]") || !strings.Contains(buf.String(), "[rows affected: 1, last insert id: 1]") {
+		t.Fatalf("unexpected log %q", buf.String())
+	}
+
+	buf.Reset()
+	if _, err := c.NamedExec("update users set name = :name where id = :id", map[string]interface{}{"id": 7, "name": "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "[name=alice, id=7]") {
+		t.Fatalf("unexpected log %q", buf.String())
+	}
+
+	if got := namedParams("select a::text, :b, x := :c.d from t"); len(got) != 2 || got[0] != "b" || got[1] != "c.d" {
+		t.Fatalf("unexpected named params %q", got)
+	}
+}
+
+func TestLoggedRows(t *testing.T) {
+	d := fakedriver.New()
+	var events []*QueryEvent
+	c := OpenClusterDB(d.Name, d.Connector("primary"), nil, WithEnableLog(true),
+		WithLogger(QueryLoggerFunc(func(ctx context.Context, e *QueryEvent) {
+			events = append(events, e)
+		})))
+	defer c.Close()
+
+	rows, err := c.Queryx("select 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatal("expected the query to be logged on close")
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+	if len(events) != 1 || events[0].Rows != 3 || events[0].Op != OpQuery {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	rows, err = c.NamedQuery("select 2 where a = :a", map[string]interface{}{"a": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	if len(events) != 2 || events[1].Rows != 0 || len(events[1].ArgNames) != 1 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	c.Query("fail")
+	if len(events) != 3 || events[2].Err == nil || events[2].Rows != -1 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+}
+
+func TestLoggedTxLifecycle(t *testing.T) {
+	d := fakedriver.New()
+	var events []*QueryEvent
+	c := OpenClusterDB(d.Name, d.Connector("primary"), nil, WithEnableLog(true), WithLongTxThreshold(time.Hour),
+		WithLogger(QueryLoggerFunc(func(ctx context.Context, e *QueryEvent) {
+			events = append(events, e)
+		})))
+	defer c.Close()
+
+	tx, err := Begin(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Exec("update t set a = 1")
+	tx.Exec("update t set b = 2")
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d", len(events))
+	}
+	ops := []Op{OpBegin, OpExec, OpExec, OpCommit}
+	for i, e := range events {
+		if e.Op != ops[i] || e.TxID == "" || e.TxID != events[0].TxID {
+			t.Fatalf("unexpected event %d: %s %q", i, e.Op, e.TxID)
+		}
+	}
+	commit := events[3]
+	if commit.Statements != 2 || commit.TxDuration < commit.Duration || commit.Slow {
+		t.Fatalf("unexpected commit event %+v", commit)
+	}
+
+	c.SetLongTxThreshold(time.Nanosecond)
+	tx, _ = Begin(c)
+	tx.Rollback()
+	rollback := events[len(events)-1]
+	if rollback.Op != OpRollback || rollback.TxID == commit.TxID || !rollback.Slow || rollback.Level != LevelWarn {
+		t.Fatalf("unexpected rollback event %+v", rollback)
+	}
+}
+
+func TestLoggedStmtAndConn(t *testing.T) {
+	d := fakedriver.New()
+	var events []*QueryEvent
+	c := OpenClusterDB(d.Name, d.Connector("primary"), nil, WithEnableLog(true),
+		WithLogger(QueryLoggerFunc(func(ctx context.Context, e *QueryEvent) {
+			events = append(events, e)
+		})))
+	defer c.Close()
+
+	stmt, err := c.Preparex("update t set a = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt.Exec(1)
+	stmt.Exec(2)
+	stmt.Close()
+	if len(events) != 3 || events[1].Op != OpExec || events[1].Query != "update t set a = ?" || events[2].Args[0] != 2 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	events = nil
+	nstmt, err := c.PrepareNamed("update t set a = :a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nstmt.Exec(map[string]interface{}{"a": 3})
+	nstmt.Close()
+	if len(events) != 2 || events[1].Op != OpNamedExec || events[1].Query != "update t set a = :a" || events[1].ArgNames[0] != "a" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	events = nil
+	conn, err := c.Connx(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := conn.QueryxContext(context.Background(), "select 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+	conn.Close()
+	if len(events) != 1 || events[0].Op != OpQuery || events[0].Rows != 2 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	events = nil
+	tx, err := Begin(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tstmt, err := tx.Preparex("update t set b = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tstmt.Exec(4)
+	tx.Commit()
+	if len(events) != 4 || events[2].Op != OpExec || events[2].TxID == "" || events[2].TxID != events[0].TxID || events[3].Statements != 2 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+}
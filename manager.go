@@ -1,62 +1,359 @@
 package sqlxcluster
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+var ErrNotFound = errors.New("sqlxcluster: db not found")
+
+const (
+	defaultLazyMinBackoff = time.Second
+	defaultLazyMaxBackoff = time.Minute
+)
+
+// NewDBManager panics if opts are invalid, see Validate. The logging options
+// are applied to the pools added to the manager, logged under the name they
+// are registered with, except for ClusterDBs, which keep their own.
+func NewDBManager(opts ...Option) *DBManager {
+	os := newOptions(opts)
+	if err := os.validate(); err != nil {
+		panic(err)
+	}
+	m := &DBManager{os: os, lazyAddFunc: os.lazyAdd}
+	if os.idleTimeout > 0 {
+		m.stop = make(chan struct{})
+		go m.janitor(os.idleTimeout)
+	}
+	m.resolver = os.resolver
+	return m
+}
+
 type DBManager struct {
 	mutex       sync.RWMutex
-	pools       map[string]DB
+	os          options
+	pools       map[string]*pool
 	lazyAddFunc func(name string) (DB, error)
+	evictFunc   func(name string, db DB)
+	addFunc     func(name string, db DB)
+	removeFunc  func(name string, db DB)
+	errorFunc   func(name string, err error)
+	resolver    func(ctx context.Context) (string, error)
+	aliases     map[string]string
+	defaultName string
+	calls       map[string]*lazyCall
+	failures    map[string]*lazyFailure
+	stop        chan struct{}
+	closed      bool
+}
+
+type pool struct {
+	db   DB
+	lazy bool
+	used int64 // unix nanoseconds, updated atomically
+}
+
+func (p *pool) touch() {
+	atomic.StoreInt64(&p.used, time.Now().UnixNano())
+}
+
+type lazyCall struct {
+	done chan struct{}
+	db   DB
+	err  error
+}
+
+type lazyFailure struct {
+	err     error
+	backoff time.Duration
+	until   time.Time
 }
 
+// Add registers db under name. It does nothing once the manager is closed,
+// see TryAdd.
 func (m *DBManager) Add(name string, db DB) {
+	m.TryAdd(name, db)
+}
+
+// TryAdd is Add returning sql.ErrConnDone, leaving db to the caller, once the
+// manager is closed.
+func (m *DBManager) TryAdd(name string, db DB) error {
+	db = m.prepare(name, db)
 	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	if m.closed {
+		m.mutex.Unlock()
+		return sql.ErrConnDone
+	}
 	if m.pools == nil {
-		m.pools = make(map[string]DB)
+		m.pools = make(map[string]*pool)
+	}
+	old := m.pools[name]
+	m.pools[name] = newPool(db, false)
+	m.mutex.Unlock()
+	if old != nil && old.db != db {
+		m.emitRemove(name, old.db)
 	}
-	m.pools[name] = db
+	m.emitAdd(name, db)
+	return nil
+}
+
+func newPool(db DB, lazy bool) *pool {
+	p := &pool{db: db, lazy: lazy}
+	p.touch()
+	return p
+}
+
+func (m *DBManager) prepare(name string, db DB) DB {
+	if db == nil {
+		return db
+	}
+	if _, ok := db.(*ClusterDB); ok {
+		// Configured by its owner, who may share it outside of the manager.
+		return db
+	}
+	if m.os.logSet {
+		if m.os.enableLog {
+			return NewLoggedDB(db, WithLogger(m.os.logger), WithColor(m.os.color), WithOutput(m.os.out), WithName(name))
+		}
+		return unwrapLoggedDB(db)
+	}
+	return db
 }
 
 func (m *DBManager) OnLazyAdd(f func(name string) (DB, error)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 	m.lazyAddFunc = f
 }
 
+// OnEvict registers f to be called after an idle lazily added pool has been
+// closed and removed.
+func (m *DBManager) OnEvict(f func(name string, db DB)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.evictFunc = f
+}
+
+// Get returns the pool called name. Missing pools are created with the lazy
+// add function, once per name however many callers wait for it, and without
+// blocking lookups of other names. A failed creation is remembered and
+// returned until its backoff expires.
 func (m *DBManager) Get(name string) (DB, error) {
 	m.mutex.RLock()
-	db := m.pools[name]
-	m.mutex.RUnlock()
-	if db != nil {
-		return db, nil
+	if target, ok := m.aliases[name]; ok {
+		name = target
+	}
+	p := m.pools[name]
+	if p != nil && p.db != nil {
+		// Under the lock, so that an eviction sees the pool in use.
+		p.touch()
 	}
 	f := m.lazyAddFunc
-	if f != nil {
-		var err error
-		func() {
-			m.mutex.Lock()
-			defer m.mutex.Unlock()
+	m.mutex.RUnlock()
+	if p != nil && p.db != nil {
+		return p.db, nil
+	}
+	if f == nil {
+		return nil, ErrNotFound
+	}
+	return m.lazyGet(name, f)
+}
 
-			if m.pools == nil {
-				m.pools = make(map[string]DB)
-			}
-			db = m.pools[name]
-			if db == nil {
-				db, err = f(name)
-				if err == nil {
-					m.pools[name] = db
-				}
-			}
-		}()
+func (m *DBManager) lazyGet(name string, f func(name string) (DB, error)) (DB, error) {
+	m.mutex.Lock()
+	if m.closed {
+		m.mutex.Unlock()
+		return nil, sql.ErrConnDone
+	}
+	if p := m.pools[name]; p != nil && p.db != nil {
+		p.touch()
+		m.mutex.Unlock()
+		return p.db, nil
+	}
+	if e := m.failures[name]; e != nil && time.Now().Before(e.until) {
+		m.mutex.Unlock()
+		return nil, e.err
+	}
+	call := m.calls[name]
+	if call == nil {
+		call = &lazyCall{done: make(chan struct{})}
+		if m.calls == nil {
+			m.calls = make(map[string]*lazyCall)
+		}
+		m.calls[name] = call
+		m.mutex.Unlock()
+		m.lazyAdd(name, f, call)
+	} else {
+		m.mutex.Unlock()
+	}
+	<-call.done
+	return call.db, call.err
+}
+
+func (m *DBManager) lazyAdd(name string, f func(name string) (DB, error), call *lazyCall) {
+	defer close(call.done)
+	db, err := callLazyAdd(name, f)
+	if err == nil && db == nil {
+		err = ErrNotFound
+	}
+	if err == nil {
+		db = m.prepare(name, db)
+	}
+
+	var evicted map[string]DB
+	var added DB
+	m.mutex.Lock()
+	defer func() {
+		m.mutex.Unlock()
 		if err != nil {
-			return nil, err
+			m.emitLazyAddError(name, err)
+		}
+		if added != nil {
+			m.emitAdd(name, added)
+		}
+		m.closeEvicted(evicted)
+	}()
+	delete(m.calls, name)
+	if err != nil {
+		backoff := m.os.lazyMinBackoff
+		if backoff <= 0 {
+			backoff = defaultLazyMinBackoff
+		}
+		if e := m.failures[name]; e != nil && !e.expired(time.Now()) {
+			backoff = e.backoff * 2
+		}
+		max := m.os.lazyMaxBackoff
+		if max <= 0 {
+			max = defaultLazyMaxBackoff
 		}
+		if backoff > max {
+			backoff = max
+		}
+		if m.failures == nil {
+			m.failures = make(map[string]*lazyFailure)
+		}
+		now := time.Now()
+		m.pruneFailuresLocked(now)
+		m.failures[name] = &lazyFailure{err: err, backoff: backoff, until: now.Add(backoff)}
+		call.err = err
+		return
+	}
+	delete(m.failures, name)
+	if m.closed {
+		db.Close()
+		call.err = sql.ErrConnDone
+		return
+	}
+	if existing := m.pools[name]; existing != nil && existing.db != nil {
+		db.Close()
+		call.db = existing.db
+		return
+	}
+	if m.pools == nil {
+		m.pools = make(map[string]*pool)
+	}
+	m.pools[name] = newPool(db, true)
+	call.db = db
+	added = db
+	evicted = m.evictOverflowLocked(name)
+}
+
+// callLazyAdd turns a panic of f into an error.
+func callLazyAdd(name string, f func(name string) (DB, error)) (db DB, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			db, err = nil, fmt.Errorf("sqlxcluster: lazy add %s: panic: %v", name, r)
+		}
+	}()
+	return f(name)
+}
+
+// expired reports whether the backoff starts again from the minimum.
+func (e *lazyFailure) expired(now time.Time) bool {
+	return now.After(e.until.Add(e.backoff))
+}
+
+// pruneFailuresLocked forgets the expired failures.
+func (m *DBManager) pruneFailuresLocked(now time.Time) {
+	for name, e := range m.failures {
+		if e.expired(now) {
+			delete(m.failures, name)
+		}
+	}
+}
+
+// FromContext returns the pool for the name resolved from ctx. The resolver
+// defaults to the name stored with NewNameContext, and an empty name selects
+// the default pool.
+func (m *DBManager) FromContext(ctx context.Context) (DB, error) {
+	m.mutex.RLock()
+	resolver := m.resolver
+	defaultName := m.defaultName
+	m.mutex.RUnlock()
+	if resolver == nil {
+		resolver = nameFromContext
 	}
-	if db != nil {
-		return db, nil
+	name, err := resolver(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return nil, sql.ErrConnDone
+	if name == "" {
+		name = defaultName
+	}
+	if name == "" {
+		return nil, ErrNotFound
+	}
+	return m.Get(name)
+}
+
+func (m *DBManager) MustFromContext(ctx context.Context) DB {
+	db, err := m.FromContext(ctx)
+	if err == nil {
+		return db
+	}
+	panic(err)
+}
+
+func (m *DBManager) SetResolver(f func(ctx context.Context) (string, error)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.resolver = f
+}
+
+func (m *DBManager) SetDefault(name string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.defaultName = name
+}
+
+// Alias makes Get(alias) return the pool called name.
+func (m *DBManager) Alias(alias string, name string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.aliases == nil {
+		m.aliases = make(map[string]string)
+	}
+	m.aliases[alias] = name
+}
+
+type nameContextKey struct{}
+
+// NewNameContext returns a copy of ctx carrying the pool name used by the
+// default resolver of DBManager.FromContext.
+func NewNameContext(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, nameContextKey{}, name)
+}
+
+func nameFromContext(ctx context.Context) (string, error) {
+	name, _ := ctx.Value(nameContextKey{}).(string)
+	return name, nil
 }
 
 func (m *DBManager) MustGet(name string) DB {
@@ -82,7 +379,306 @@ func (m *DBManager) DBs() []DB {
 	defer m.mutex.RUnlock()
 	var ls []DB
 	for _, v := range m.pools {
-		ls = append(ls, v)
+		ls = append(ls, v.db)
 	}
 	return ls
 }
+
+// Remove unregisters the pool called name and closes it.
+func (m *DBManager) Remove(name string) error {
+	m.mutex.Lock()
+	p, ok := m.pools[name]
+	delete(m.pools, name)
+	m.mutex.Unlock()
+	if !ok || p.db == nil {
+		return nil
+	}
+	err := p.db.Close()
+	m.emitRemove(name, p.db)
+	return err
+}
+
+// Replace registers db under name and closes the pool it replaces. Close
+// waits for the queries already running on the old pool to finish. Like
+// TryAdd, it returns sql.ErrConnDone once the manager is closed.
+func (m *DBManager) Replace(name string, db DB) error {
+	db = m.prepare(name, db)
+	m.mutex.Lock()
+	if m.closed {
+		m.mutex.Unlock()
+		return sql.ErrConnDone
+	}
+	if m.pools == nil {
+		m.pools = make(map[string]*pool)
+	}
+	old := m.pools[name]
+	m.pools[name] = newPool(db, false)
+	m.mutex.Unlock()
+	if old == nil || old.db == nil || old.db == db {
+		m.emitAdd(name, db)
+		return nil
+	}
+	err := old.db.Close()
+	m.emitRemove(name, old.db)
+	m.emitAdd(name, db)
+	return err
+}
+
+// Close closes every pool in parallel and stops lazy additions. It returns
+// when all pools are closed or ctx is done, whichever comes first.
+func (m *DBManager) Close(ctx context.Context) error {
+	m.mutex.Lock()
+	pools := m.pools
+	m.pools = nil
+	if m.stop != nil && !m.closed {
+		close(m.stop)
+	}
+	m.closed = true
+	m.mutex.Unlock()
+
+	type result struct {
+		name string
+		err  error
+	}
+	ch := make(chan result, len(pools))
+	for name, p := range pools {
+		if p.db == nil {
+			ch <- result{name: name}
+			continue
+		}
+		go func(name string, db DB) {
+			err := db.Close()
+			m.emitRemove(name, db)
+			ch <- result{name: name, err: err}
+		}(name, p.db)
+	}
+
+	var errs multiError
+	for i := 0; i < len(pools); i++ {
+		select {
+		case r := <-ch:
+			if r.err != nil {
+				errs = append(errs, fmt.Errorf("sqlxcluster: close %s: %w", r.name, r.err))
+			}
+		case <-ctx.Done():
+			errs = append(errs, ctx.Err())
+			return errs
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (m *DBManager) janitor(idleTimeout time.Duration) {
+	interval := idleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case now := <-t.C:
+			m.evictIdle(now)
+		}
+	}
+}
+
+// minEvictAge keeps the pools Get just returned from the overflow eviction.
+const minEvictAge = time.Second
+
+// busy reports whether connections of the pool are in use.
+func (p *pool) busy() bool {
+	if c, ok := p.db.(*ClusterDB); ok {
+		for _, s := range c.NodeStats() {
+			if s.InUse > 0 {
+				return true
+			}
+		}
+		return false
+	}
+	return p.db != nil && p.db.Stats().InUse > 0
+}
+
+// evictIdle closes the lazily added pools unused since the idle timeout.
+func (m *DBManager) evictIdle(now time.Time) {
+	idleTimeout := m.os.idleTimeout
+	if idleTimeout <= 0 {
+		return
+	}
+	deadline := now.Add(-idleTimeout).UnixNano()
+	evicted := make(map[string]DB)
+	m.mutex.Lock()
+	for name, p := range m.pools {
+		if p.lazy && atomic.LoadInt64(&p.used) < deadline && !p.busy() {
+			evicted[name] = p.db
+			delete(m.pools, name)
+		}
+	}
+	m.mutex.Unlock()
+	m.closeEvicted(evicted)
+}
+
+// evictOverflowLocked removes the least recently used lazy pools past the maximum.
+func (m *DBManager) evictOverflowLocked(keep string) map[string]DB {
+	max := m.os.maxPools
+	if max <= 0 {
+		return nil
+	}
+	var lazy []string
+	for name, p := range m.pools {
+		if p.lazy && name != keep {
+			lazy = append(lazy, name)
+		}
+	}
+	n := len(lazy) + 1 - max
+	if n <= 0 {
+		return nil
+	}
+	sort.Slice(lazy, func(i, j int) bool {
+		return atomic.LoadInt64(&m.pools[lazy[i]].used) < atomic.LoadInt64(&m.pools[lazy[j]].used)
+	})
+	recent := time.Now().Add(-minEvictAge).UnixNano()
+	evicted := make(map[string]DB)
+	for _, name := range lazy {
+		if len(evicted) == n {
+			break
+		}
+		p := m.pools[name]
+		if atomic.LoadInt64(&p.used) >= recent || p.busy() {
+			continue
+		}
+		evicted[name] = p.db
+		delete(m.pools, name)
+	}
+	return evicted
+}
+
+func (m *DBManager) closeEvicted(evicted map[string]DB) {
+	if len(evicted) == 0 {
+		return
+	}
+	m.mutex.RLock()
+	f := m.evictFunc
+	m.mutex.RUnlock()
+	for name, db := range evicted {
+		if db == nil {
+			continue
+		}
+		db.Close()
+		if f != nil {
+			f(name, db)
+		}
+		m.emitRemove(name, db)
+	}
+}
+
+// OnAdd registers f to be called after a pool is added, lazily or not.
+func (m *DBManager) OnAdd(f func(name string, db DB)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.addFunc = f
+}
+
+// OnRemove registers f to be called after a pool leaves the manager, whether
+// removed, replaced, evicted or closed with the manager.
+func (m *DBManager) OnRemove(f func(name string, db DB)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.removeFunc = f
+}
+
+func (m *DBManager) OnLazyAddError(f func(name string, err error)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.errorFunc = f
+}
+
+func (m *DBManager) emitAdd(name string, db DB) {
+	m.mutex.RLock()
+	f := m.addFunc
+	m.mutex.RUnlock()
+	if f != nil && db != nil {
+		f(name, db)
+	}
+}
+
+func (m *DBManager) emitRemove(name string, db DB) {
+	m.mutex.RLock()
+	f := m.removeFunc
+	m.mutex.RUnlock()
+	if f != nil && db != nil {
+		f(name, db)
+	}
+}
+
+func (m *DBManager) emitLazyAddError(name string, err error) {
+	m.mutex.RLock()
+	f := m.errorFunc
+	m.mutex.RUnlock()
+	if f != nil {
+		f(name, err)
+	}
+}
+
+type PoolStats struct {
+	sql.DBStats
+	Nodes []NodeStats // set for a ClusterDB only
+}
+
+// Stats returns a snapshot of the connection pool statistics of every pool,
+// keyed by name.
+func (m *DBManager) Stats() map[string]PoolStats {
+	m.mutex.RLock()
+	pools := make(map[string]DB, len(m.pools))
+	for name, p := range m.pools {
+		if p.db != nil {
+			pools[name] = p.db
+		}
+	}
+	m.mutex.RUnlock()
+	stats := make(map[string]PoolStats, len(pools))
+	for name, db := range pools {
+		s := PoolStats{DBStats: db.Stats()}
+		if c, ok := db.(*ClusterDB); ok {
+			s.Nodes = c.NodeStats()
+		}
+		stats[name] = s
+	}
+	return stats
+}
+
+type multiError []error
+
+func (e multiError) Error() string {
+	var b strings.Builder
+	for i, err := range e {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+func (e multiError) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e multiError) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
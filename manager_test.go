@@ -0,0 +1,316 @@
+package sqlxcluster
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-comm/sqlxcluster/internal/fakedriver"
+)
+
+type closeDB struct {
+	DB
+	closed  int32
+	err     error
+	delay   time.Duration
+	barrier *sync.WaitGroup // waited for by Close, once done
+	inUse   int32
+}
+
+func (db *closeDB) Stats() sql.DBStats {
+	return sql.DBStats{InUse: int(atomic.LoadInt32(&db.inUse))}
+}
+
+func (db *closeDB) Close() error {
+	if db.barrier != nil {
+		db.barrier.Done()
+		db.barrier.Wait()
+	}
+	time.Sleep(db.delay)
+	atomic.AddInt32(&db.closed, 1)
+	return db.err
+}
+
+func TestManagerReplaceAndRemove(t *testing.T) {
+	var m DBManager
+	a, b := &closeDB{}, &closeDB{}
+	m.Add("a", a)
+	if err := m.Replace("a", b); err != nil {
+		t.Fatal(err)
+	}
+	if a.closed != 1 || b.closed != 0 {
+		t.Fatalf("expected only the old pool closed, got %d %d", a.closed, b.closed)
+	}
+	if db, _ := m.Get("a"); db != b {
+		t.Fatal("expected replaced pool")
+	}
+	if err := m.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	if b.closed != 1 || len(m.Names()) != 0 {
+		t.Fatal("expected removed pool to be closed")
+	}
+}
+
+func TestManagerClose(t *testing.T) {
+	// Every Close waits for the others to start: closing one pool after the
+	// other would run into the deadline.
+	var barrier sync.WaitGroup
+	barrier.Add(3)
+	var m DBManager
+	m.Add("a", &closeDB{barrier: &barrier})
+	m.Add("b", &closeDB{barrier: &barrier, err: errors.New("boom")})
+	bang := &os.PathError{Op: "close", Path: "c", Err: errors.New("bang")}
+	m.Add("c", &closeDB{barrier: &barrier, err: bang})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := m.Close(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected pools to close in parallel")
+	}
+	if err == nil || !strings.Contains(err.Error(), "close b: boom") || !strings.Contains(err.Error(), "close c: close c: bang") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var perr *os.PathError
+	if !errors.Is(err, bang) || !errors.As(err, &perr) || perr != bang {
+		t.Fatalf("expected the close errors to be wrapped, got %v", err)
+	}
+	if err := m.TryAdd("d", &closeDB{}); err != sql.ErrConnDone {
+		t.Fatalf("expected a closed manager, got %v", err)
+	}
+	m.Add("d", &closeDB{})
+	if len(m.Names()) != 0 {
+		t.Fatal("expected a closed manager to stay empty")
+	}
+	if err := m.Replace("a", &closeDB{}); err != sql.ErrConnDone {
+		t.Fatalf("expected a closed manager, got %v", err)
+	}
+
+	var slow DBManager
+	slow.Add("d", &closeDB{delay: time.Second})
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := slow.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+}
+
+func TestManagerLazyAddSingleflight(t *testing.T) {
+	var calls int32
+	slow := make(chan struct{})
+	m := NewDBManager(WithLazyAdd(func(name string) (DB, error) {
+		atomic.AddInt32(&calls, 1)
+		if name == "slow" {
+			<-slow
+		}
+		return &closeDB{}, nil
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := m.Get("slow"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, err := m.Get("fast"); err != nil {
+		t.Fatal(err)
+	}
+	close(slow)
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("expected 2 lazy adds, got %d", n)
+	}
+}
+
+func TestManagerLazyAddBackoff(t *testing.T) {
+	var calls int32
+	m := NewDBManager(WithLazyBackoff(20*time.Millisecond, time.Second), WithLazyAdd(func(name string) (DB, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, errors.New("unreachable")
+	}))
+	for i := 0; i < 3; i++ {
+		if _, err := m.Get("a"); err == nil || err.Error() != "unreachable" {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected failure to be cached, got %d calls", calls)
+	}
+	time.Sleep(30 * time.Millisecond)
+	m.Get("a")
+	if calls != 2 {
+		t.Fatalf("expected retry after backoff, got %d calls", calls)
+	}
+
+	var empty DBManager
+	if _, err := empty.Get("a"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestManagerEviction(t *testing.T) {
+	pools := make(map[string]*closeDB)
+	m := NewDBManager(WithMaxPools(2), WithIdleTimeout(time.Hour), WithLazyAdd(func(name string) (DB, error) {
+		pools[name] = &closeDB{}
+		return pools[name], nil
+	}))
+	defer m.Close(context.Background())
+	var evicted []string
+	m.OnEvict(func(name string, db DB) {
+		evicted = append(evicted, name)
+	})
+	age := func(name string, d time.Duration) {
+		m.mutex.RLock()
+		atomic.StoreInt64(&m.pools[name].used, time.Now().Add(-d).UnixNano())
+		m.mutex.RUnlock()
+	}
+
+	static := &closeDB{}
+	m.Add("static", static)
+	m.Get("a")
+	m.Get("b")
+	age("a", 3*time.Second)
+	age("b", 2*time.Second)
+	m.Get("a")
+	m.Get("c")
+	if strings.Join(evicted, ",") != "b" {
+		t.Fatalf("expected least recently used pool evicted, got %v", evicted)
+	}
+	m.Get("d")
+	if len(evicted) != 1 || len(m.Names()) != 4 {
+		t.Fatalf("expected the pools returned just before to be kept, got %v", evicted)
+	}
+
+	atomic.StoreInt32(&pools["a"].inUse, 1)
+	m.evictIdle(time.Now().Add(2 * time.Hour))
+	if len(evicted) != 3 || pools["a"].closed != 0 || static.closed != 0 {
+		t.Fatalf("expected idle lazy pools not in use evicted, got %v", evicted)
+	}
+	atomic.StoreInt32(&pools["a"].inUse, 0)
+	m.evictIdle(time.Now().Add(2 * time.Hour))
+	if names := m.Names(); len(names) != 1 || names[0] != "static" {
+		t.Fatalf("unexpected pools left: %v", names)
+	}
+	if _, err := m.Get("a"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type tenantKey struct{}
+
+func TestManagerFromContext(t *testing.T) {
+	m := NewDBManager(WithResolver(func(ctx context.Context) (string, error) {
+		tenant, _ := ctx.Value(tenantKey{}).(string)
+		return tenant, nil
+	}))
+	eu, us := &closeDB{}, &closeDB{}
+	m.Add("eu-1", eu)
+	m.Add("us-1", us)
+	m.Alias("acme", "eu-1")
+	m.SetDefault("us-1")
+
+	ctx := context.Background()
+	if db, err := m.FromContext(context.WithValue(ctx, tenantKey{}, "acme")); err != nil || db != eu {
+		t.Fatalf("expected aliased pool, got %v %v", db, err)
+	}
+	if db, err := m.FromContext(ctx); err != nil || db != us {
+		t.Fatalf("expected default pool, got %v %v", db, err)
+	}
+	if _, err := m.FromContext(context.WithValue(ctx, tenantKey{}, "unknown")); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	var plain DBManager
+	plain.Add("eu-1", eu)
+	if db, err := plain.FromContext(NewNameContext(ctx, "eu-1")); err != nil || db != eu {
+		t.Fatalf("expected pool from name context, got %v %v", db, err)
+	}
+}
+
+func TestManagerEventsAndStats(t *testing.T) {
+	d := fakedriver.New()
+	var mutex sync.Mutex
+	var events []string
+	record := func(event string) {
+		mutex.Lock()
+		events = append(events, event)
+		mutex.Unlock()
+	}
+	m := NewDBManager(WithLazyAdd(func(name string) (DB, error) {
+		if name == "down" {
+			return nil, errors.New("unreachable")
+		}
+		return OpenClusterDB(d.Name, d.Connector(name), []driver.Connector{d.Connector(name)}, WithNodeNames("", "10.0.0.2:5432")), nil
+	}))
+	m.OnAdd(func(name string, db DB) { record("add " + name) })
+	m.OnRemove(func(name string, db DB) { record("remove " + name) })
+	m.OnLazyAddError(func(name string, err error) { record("error " + name) })
+
+	m.Get("a")
+	m.Get("down")
+	stats := m.Stats()
+	nodes := stats["a"].Nodes
+	if len(stats) != 1 || len(nodes) != 2 || nodes[0].Name != "primary" || nodes[1].Name != "10.0.0.2:5432" || nodes[1].Role != RoleReplica {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	m.Remove("a")
+	if got := strings.Join(events, ", "); got != "add a, error down, remove a" {
+		t.Fatalf("unexpected events: %s", got)
+	}
+}
+
+func TestManagerKeepsClusterConfig(t *testing.T) {
+	d := fakedriver.New()
+	m := NewDBManager(WithEnableLog(true), WithLogger(QueryLoggerFunc(func(ctx context.Context, e *QueryEvent) {})))
+	c := OpenClusterDB(d.Name, d.Connector("primary"), nil)
+	defer c.Close()
+	m.Add("a", c)
+	if db, _ := m.Get("a"); db != c || c.Name() != "" || c.Logged() {
+		t.Fatal("expected the cluster to be registered as it is")
+	}
+}
+
+func TestManagerLazyAddPanic(t *testing.T) {
+	var calls int32
+	m := NewDBManager(WithLazyBackoff(time.Millisecond, time.Millisecond), WithLazyAdd(func(name string) (DB, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			panic("boom")
+		}
+		return &closeDB{}, nil
+	}))
+	if db, err := m.Get("a"); db != nil || err == nil || !strings.Contains(err.Error(), "panic: boom") {
+		t.Fatalf("expected the panic as an error, got %v %v", db, err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if db, err := m.Get("a"); db == nil || err != nil {
+		t.Fatalf("expected the name to be added again, got %v %v", db, err)
+	}
+}
+
+func TestManagerPrunesFailures(t *testing.T) {
+	m := NewDBManager(WithLazyBackoff(time.Millisecond, time.Millisecond), WithLazyAdd(func(name string) (DB, error) {
+		return nil, errors.New("unreachable")
+	}))
+	m.Get("a")
+	m.Get("b")
+	time.Sleep(5 * time.Millisecond)
+	m.Get("c")
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if len(m.failures) != 1 || m.failures["c"] == nil {
+		t.Fatalf("expected expired failures pruned, got %v", m.failures)
+	}
+}
@@ -0,0 +1,187 @@
+// Package metrics exports Prometheus metrics of sqlxcluster: the duration of
+// statements, how reads are routed, the outcome of transactions and the
+// connection pool statistics of every node.
+//
+//	m := metrics.New(registry)
+//	db := sqlxcluster.OpenClusterDB(driverName, primary, replicas,
+//		sqlxcluster.WithInterceptors(m), sqlxcluster.WithRouteObserver(m))
+//	registry.MustRegister(metrics.NewStatsCollector(db))
+package metrics
+
+import (
+	"context"
+
+	"github.com/go-comm/sqlxcluster"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	_ sqlxcluster.Interceptor   = (*Metrics)(nil)
+	_ sqlxcluster.RouteObserver = (*Metrics)(nil)
+)
+
+type Option func(c *config)
+
+type config struct {
+	namespace string
+	buckets   []float64
+}
+
+func newConfig(opts []Option) config {
+	c := config{namespace: "sqlxcluster", buckets: prometheus.DefBuckets}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// WithNamespace prefixes the metric names with namespace instead of
+// sqlxcluster.
+func WithNamespace(namespace string) Option {
+	return func(c *config) {
+		c.namespace = namespace
+	}
+}
+
+// WithBuckets sets the buckets of the duration histograms, in seconds.
+func WithBuckets(buckets []float64) Option {
+	return func(c *config) {
+		c.buckets = buckets
+	}
+}
+
+// Metrics is an interceptor and a route observer recording into the
+// collectors registered by New.
+type Metrics struct {
+	queries      *prometheus.HistogramVec
+	routes       *prometheus.CounterVec
+	transactions *prometheus.CounterVec
+	txDurations  *prometheus.HistogramVec
+}
+
+// New registers the query, route and transaction metrics with reg. It
+// panics if they are already registered.
+func New(reg prometheus.Registerer, opts ...Option) *Metrics {
+	c := newConfig(opts)
+	m := &Metrics{
+		queries: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: c.namespace,
+			Name:      "query_duration_seconds",
+			Help:      "Duration of the statements, including the iteration over their rows.",
+			Buckets:   c.buckets,
+		}, []string{"cluster", "node", "operation", "status"}),
+		routes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: c.namespace,
+			Name:      "routes_total",
+			Help:      "Reads routed to each node, by route.",
+		}, []string{"cluster", "node", "route"}),
+		transactions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: c.namespace,
+			Name:      "transactions_total",
+			Help:      "Transactions ended, by outcome.",
+		}, []string{"cluster", "node", "outcome", "status"}),
+		txDurations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: c.namespace,
+			Name:      "transaction_duration_seconds",
+			Help:      "Duration of the transactions, from begin to commit or rollback.",
+			Buckets:   c.buckets,
+		}, []string{"cluster", "node", "outcome"}),
+	}
+	reg.MustRegister(m.queries, m.routes, m.transactions, m.txDurations)
+	return m
+}
+
+func (m *Metrics) Before(ctx context.Context, e *sqlxcluster.QueryEvent) (context.Context, error) {
+	return ctx, nil
+}
+
+func (m *Metrics) After(ctx context.Context, e *sqlxcluster.QueryEvent) {
+	m.queries.WithLabelValues(e.Cluster, e.Node, string(e.Op), status(e)).Observe(e.Duration.Seconds())
+	switch e.Op {
+	case sqlxcluster.OpCommit, sqlxcluster.OpRollback:
+		m.transactions.WithLabelValues(e.Cluster, e.Node, string(e.Op), status(e)).Inc()
+		m.txDurations.WithLabelValues(e.Cluster, e.Node, string(e.Op)).Observe(e.TxDuration.Seconds())
+	}
+}
+
+func (m *Metrics) ObserveRoute(cluster string, node string, route sqlxcluster.Route) {
+	m.routes.WithLabelValues(cluster, node, route.String()).Inc()
+}
+
+func status(e *sqlxcluster.QueryEvent) string {
+	if e.Failed() {
+		return "error"
+	}
+	return "ok"
+}
+
+type statsCollector struct {
+	db          *sqlxcluster.ClusterDB
+	maxOpen     *prometheus.Desc
+	open        *prometheus.Desc
+	inUse       *prometheus.Desc
+	idle        *prometheus.Desc
+	waitCount   *prometheus.Desc
+	waitTime    *prometheus.Desc
+	maxIdle     *prometheus.Desc
+	maxIdleTime *prometheus.Desc
+	maxLifetime *prometheus.Desc
+}
+
+// NewStatsCollector returns a collector of the connection pool statistics of
+// every node of db, see ClusterDB.NodeStats. The cluster label is the name of
+// db when the collector is created, which tells apart the collectors of the
+// clusters registered together.
+func NewStatsCollector(db *sqlxcluster.ClusterDB, opts ...Option) prometheus.Collector {
+	c := newConfig(opts)
+	labels := []string{"node", "role"}
+	cluster := prometheus.Labels{"cluster": db.Name()}
+	desc := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(c.namespace, "", name), help, labels, cluster)
+	}
+	return &statsCollector{
+		db:          db,
+		maxOpen:     desc("max_open_connections", "Maximum number of open connections."),
+		open:        desc("open_connections", "Established connections, in use or idle."),
+		inUse:       desc("in_use_connections", "Connections in use."),
+		idle:        desc("idle_connections", "Idle connections."),
+		waitCount:   desc("wait_count_total", "Connections waited for."),
+		waitTime:    desc("wait_duration_seconds_total", "Time blocked waiting for a connection."),
+		maxIdle:     desc("max_idle_closed_total", "Connections closed because of the maximum of idle connections."),
+		maxIdleTime: desc("max_idle_time_closed_total", "Connections closed because of the maximum idle time."),
+		maxLifetime: desc("max_lifetime_closed_total", "Connections closed because of the maximum lifetime."),
+	}
+}
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.maxOpen
+	ch <- c.open
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitTime
+	ch <- c.maxIdle
+	ch <- c.maxIdleTime
+	ch <- c.maxLifetime
+}
+
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range c.db.NodeStats() {
+		labels := []string{s.Name, s.Role.String()}
+		gauge := func(desc *prometheus.Desc, v float64) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, labels...)
+		}
+		counter := func(desc *prometheus.Desc, v float64) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, v, labels...)
+		}
+		gauge(c.maxOpen, float64(s.MaxOpenConnections))
+		gauge(c.open, float64(s.OpenConnections))
+		gauge(c.inUse, float64(s.InUse))
+		gauge(c.idle, float64(s.Idle))
+		counter(c.waitCount, float64(s.WaitCount))
+		counter(c.waitTime, s.WaitDuration.Seconds())
+		counter(c.maxIdle, float64(s.MaxIdleClosed))
+		counter(c.maxIdleTime, float64(s.MaxIdleTimeClosed))
+		counter(c.maxLifetime, float64(s.MaxLifetimeClosed))
+	}
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"database/sql/driver"
+	"testing"
+
+	"github.com/go-comm/sqlxcluster"
+	"github.com/go-comm/sqlxcluster/internal/fakedriver"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	m := New(reg)
+	d := fakedriver.New()
+	db := sqlxcluster.OpenClusterDB(d.Name, d.Connector("primary"), []driver.Connector{d.Connector("replica")},
+		sqlxcluster.WithName("users"), sqlxcluster.WithInterceptors(m), sqlxcluster.WithRouteObserver(m))
+	defer db.Close()
+	reg.MustRegister(NewStatsCollector(db))
+
+	db.Exec("update t set a = 1")
+	db.Exec("fail")
+	var n int
+	db.Get(&n, "select n from t")
+	tx, err := sqlxcluster.Begin(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Exec("update t set a = 2")
+	tx.Rollback()
+
+	if got := testutil.ToFloat64(m.routes.WithLabelValues("users", "replica-0", "replica")); got != 1 {
+		t.Fatalf("expected 1 read routed to the replica, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.transactions.WithLabelValues("users", "primary", "rollback", "ok")); got != 1 {
+		t.Fatalf("expected 1 rollback, got %v", got)
+	}
+	// exec, failed exec, get, begin and rollback: the exec of the transaction
+	// shares the series of the first one.
+	if got := testutil.CollectAndCount(m.queries); got != 5 {
+		t.Fatalf("expected 5 query series, got %d", got)
+	}
+	if got := testutil.CollectAndCount(reg, "sqlxcluster_open_connections", "sqlxcluster_in_use_connections"); got != 4 {
+		t.Fatalf("expected the stats of 2 nodes, got %d series", got)
+	}
+
+	orders := sqlxcluster.OpenClusterDB(d.Name, d.Connector("primary"), nil, sqlxcluster.WithName("orders"))
+	defer orders.Close()
+	if err := reg.Register(NewStatsCollector(orders)); err != nil {
+		t.Fatalf("expected the stats of a second cluster to register, got %v", err)
+	}
+	if got := testutil.CollectAndCount(reg, "sqlxcluster_open_connections"); got != 3 {
+		t.Fatalf("expected the stats of 3 nodes, got %d series", got)
+	}
+	if problems, err := testutil.GatherAndLint(reg); err != nil || len(problems) > 0 {
+		t.Fatalf("lint: %v %v", err, problems)
+	}
+}
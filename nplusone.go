@@ -0,0 +1,101 @@
+package sqlxcluster
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// NPlusOne reports a fingerprint run more times than allowed in a query
+// scope, see WithNPlusOneDetection.
+type NPlusOne struct {
+	Fingerprint string
+	Query       string
+	Count       int
+	Stack       []Caller // of the statement exceeding the limit
+}
+
+func (r *NPlusOne) String() string {
+	var b strings.Builder
+	b.WriteString("sqlxcluster: N+1 queries, ")
+	b.WriteString(strconv.Itoa(r.Count))
+	b.WriteString(" times in the same scope: ")
+	b.WriteString(r.Query)
+	for _, c := range r.Stack {
+		b.WriteString("\n\t")
+		b.WriteString(c.Function)
+		b.WriteString("\n\t\t")
+		b.WriteString(c.String())
+	}
+	return b.String()
+}
+
+type queryScopeKey struct{}
+
+// queryScope counts the fingerprints of the statements run in a scope.
+type queryScope struct {
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+// WithQueryScope starts a scope, usually a request, in which the statements
+// run with ctx or a context derived from it are counted by fingerprint. A
+// scope already in ctx is left as is.
+func WithQueryScope(ctx context.Context) context.Context {
+	if ctx.Value(queryScopeKey{}) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, queryScopeKey{}, &queryScope{counts: make(map[string]int)})
+}
+
+// QueryScopeHandler runs h with a query scope in the context of every request.
+func QueryScopeHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(WithQueryScope(r.Context())))
+	})
+}
+
+// FailOnNPlusOne returns a report function of WithNPlusOneDetection failing
+// the test tb, such as a *testing.T.
+func FailOnNPlusOne(tb interface {
+	Helper()
+	Error(args ...interface{})
+}) func(ctx context.Context, r *NPlusOne) {
+	return func(ctx context.Context, r *NPlusOne) {
+		tb.Helper()
+		tb.Error(r.String())
+	}
+}
+
+// nPlusOneDetector is the interceptor of WithNPlusOneDetection.
+type nPlusOneDetector struct {
+	max    int
+	report func(ctx context.Context, r *NPlusOne)
+	skip   []string
+}
+
+func (d *nPlusOneDetector) Before(ctx context.Context, e *QueryEvent) (context.Context, error) {
+	return ctx, nil
+}
+
+func (d *nPlusOneDetector) After(ctx context.Context, e *QueryEvent) {
+	switch e.Op {
+	case OpPrepare, OpPrepareNamed, OpBegin, OpCommit, OpRollback:
+		return
+	}
+	scope, ok := ctx.Value(queryScopeKey{}).(*queryScope)
+	if !ok {
+		return
+	}
+	fingerprint := fingerprint(dialectOf(e.Driver), e.Query)
+	scope.mutex.Lock()
+	scope.counts[fingerprint]++
+	n := scope.counts[fingerprint]
+	scope.mutex.Unlock()
+	// Reported once per fingerprint and scope.
+	if n == d.max+1 {
+		d.report(ctx, &NPlusOne{Fingerprint: fingerprint, Query: e.Query, Count: n, Stack: findStack(d.skip)})
+	}
+}
@@ -0,0 +1,84 @@
+package sqlxcluster_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-comm/sqlxcluster"
+	"github.com/go-comm/sqlxcluster/internal/fakedriver"
+)
+
+type fakeTB struct {
+	errors []string
+}
+
+func (tb *fakeTB) Helper() {}
+
+func (tb *fakeTB) Error(args ...interface{}) {
+	tb.errors = append(tb.errors, fmt.Sprint(args...))
+}
+
+func loadAuthors(ctx context.Context, db sqlxcluster.DB, ids []int) {
+	for _, id := range ids {
+		var n int
+		db.GetContext(ctx, &n, "select 1 where id = ?", id)
+	}
+}
+
+func TestNPlusOneDetection(t *testing.T) {
+	d := fakedriver.New()
+	var reports []*sqlxcluster.NPlusOne
+	c := sqlxcluster.OpenClusterDB(d.Name, d.Connector("primary"), nil, sqlxcluster.WithNPlusOneDetection(2, func(ctx context.Context, r *sqlxcluster.NPlusOne) {
+		reports = append(reports, r)
+	}))
+	defer c.Close()
+
+	loadAuthors(context.Background(), c, []int{1, 2, 3, 4})
+	if len(reports) != 0 {
+		t.Fatal("expected no report outside of a scope")
+	}
+	ctx := sqlxcluster.WithQueryScope(context.Background())
+	loadAuthors(ctx, c, []int{1, 2})
+	c.ExecContext(ctx, "update t set a = 1")
+	if len(reports) != 0 {
+		t.Fatal("expected no report under the limit")
+	}
+	loadAuthors(ctx, c, []int{3, 4})
+	if len(reports) != 1 {
+		t.Fatalf("expected 1 report, got %d", len(reports))
+	}
+	r := reports[0]
+	if r.Fingerprint != "select ? where id = ?" || r.Count != 3 || len(r.Stack) < 2 ||
+		!strings.HasSuffix(r.Stack[0].Function, ".loadAuthors") || !strings.HasSuffix(r.Stack[1].Function, ".TestNPlusOneDetection") {
+		t.Fatalf("unexpected report %s", r)
+	}
+
+	// A scope per request.
+	reports = nil
+	h := sqlxcluster.QueryScopeHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		loadAuthors(r.Context(), c, []int{1, 2})
+	}))
+	for i := 0; i < 2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	if len(reports) != 0 {
+		t.Fatalf("expected the requests to be counted apart, got %d reports", len(reports))
+	}
+}
+
+func TestFailOnNPlusOne(t *testing.T) {
+	d := fakedriver.New()
+	tb := &fakeTB{}
+	c := sqlxcluster.OpenClusterDB(d.Name, d.Connector("primary"), nil, sqlxcluster.WithNPlusOneDetection(1, sqlxcluster.FailOnNPlusOne(tb)))
+	defer c.Close()
+
+	loadAuthors(sqlxcluster.WithQueryScope(context.Background()), c, []int{1, 2, 3})
+	if len(tb.errors) != 1 || !strings.Contains(tb.errors[0], "N+1 queries, 2 times in the same scope: select 1 where id = ?") ||
+		!strings.Contains(tb.errors[0], "nplusone_test.go:") {
+		t.Fatalf("unexpected errors %q", tb.errors)
+	}
+}
@@ -0,0 +1,369 @@
+package sqlxcluster
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Option configures NewClusterDB, OpenClusterDB, NewLoggedDB, NewLoggedTx and
+// NewDBManager. Options that do not apply to a constructor are ignored.
+type Option func(os *options)
+
+type options struct {
+	name             string
+	nodeNames        []string
+	enableLog        bool
+	logSet           bool
+	color            bool
+	out              func(b []byte) (int, error)
+	logger           QueryLogger
+	nodeName         string
+	nodeRole         Role
+	slowThreshold    time.Duration
+	sampleRate       float64
+	longTxThreshold  time.Duration
+	redaction        *Redaction
+	interpolate      bool
+	driverName       string
+	callerSkip       []string
+	interceptors     []Interceptor
+	level            Level
+	logSwitch        *logSwitch
+	queryStats       bool
+	nPlusOne         *nPlusOneDetector
+	explainInterval  time.Duration
+	explainer        *explainer
+	commentTags      []CommentTag
+	onConnect        []ConnectHook
+	onPrimaryConnect []ConnectHook
+	onReplicaConnect []ConnectHook
+	readFromPrimary  bool
+	routeObserver    RouteObserver
+	lazyAdd          func(name string) (DB, error)
+	lazyMinBackoff   time.Duration
+	lazyMaxBackoff   time.Duration
+	idleTimeout      time.Duration
+	maxPools         int
+	resolver         func(ctx context.Context) (string, error)
+}
+
+func newOptions(opts []Option) options {
+	var os options
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&os)
+		}
+	}
+	return os
+}
+
+func (os *options) validate() error {
+	if os.slowThreshold < 0 {
+		return errors.New("sqlxcluster: negative slow query threshold")
+	}
+	if os.longTxThreshold < 0 {
+		return errors.New("sqlxcluster: negative long transaction threshold")
+	}
+	if !validSampleRate(os.sampleRate) {
+		return errSampleRate
+	}
+	if os.lazyMinBackoff < 0 || os.lazyMaxBackoff < 0 {
+		return errors.New("sqlxcluster: negative lazy add backoff")
+	}
+	if os.lazyMaxBackoff > 0 && os.lazyMaxBackoff < os.lazyMinBackoff {
+		return errors.New("sqlxcluster: lazy add max backoff below min backoff")
+	}
+	if os.idleTimeout < 0 {
+		return errors.New("sqlxcluster: negative idle timeout")
+	}
+	if os.maxPools < 0 {
+		return errors.New("sqlxcluster: negative max pools")
+	}
+	for _, interceptor := range os.interceptors {
+		if interceptor == nil {
+			return errors.New("sqlxcluster: nil interceptor")
+		}
+	}
+	if os.explainInterval < 0 {
+		return errors.New("sqlxcluster: negative explain interval")
+	}
+	if os.nPlusOne != nil && (os.nPlusOne.max < 1 || os.nPlusOne.report == nil) {
+		return errors.New("sqlxcluster: N+1 detection needs a positive maximum and a report function")
+	}
+	for _, tag := range os.commentTags {
+		if tag.Key == "" || tag.Value == nil {
+			return errors.New("sqlxcluster: comment tag without key or value")
+		}
+	}
+	for _, hooks := range [][]ConnectHook{os.onConnect, os.onPrimaryConnect, os.onReplicaConnect} {
+		for _, hook := range hooks {
+			if hook == nil {
+				return errors.New("sqlxcluster: nil connect hook")
+			}
+		}
+	}
+	return nil
+}
+
+// Validate returns the error the constructors panic with when given opts.
+func Validate(opts ...Option) error {
+	os := newOptions(opts)
+	return os.validate()
+}
+
+func (os *options) hasConnectHooks() bool {
+	return len(os.onConnect) > 0 || len(os.onPrimaryConnect) > 0 || len(os.onReplicaConnect) > 0
+}
+
+func WithName(name string) Option {
+	return func(os *options) {
+		os.name = name
+	}
+}
+
+// WithNodeNames names the nodes of a ClusterDB in stats and logs, typically
+// after their address. Unnamed nodes are called primary and replica-N.
+func WithNodeNames(primary string, replicas ...string) Option {
+	return func(os *options) {
+		os.nodeNames = append([]string{primary}, replicas...)
+	}
+}
+
+func WithEnableLog(enableLog bool) Option {
+	return func(os *options) {
+		os.enableLog = enableLog
+		os.logSet = true
+	}
+}
+
+func WithColor(color bool) Option {
+	return func(os *options) {
+		os.color = color
+	}
+}
+
+func WithOutput(out func(b []byte) (int, error)) Option {
+	return func(os *options) {
+		os.out = out
+	}
+}
+
+// WithLogger sends query events to logger instead of the text output.
+func WithLogger(logger QueryLogger) Option {
+	return func(os *options) {
+		os.logger = logger
+	}
+}
+
+// WithLogLevel logs only the events of level or above.
+func WithLogLevel(level Level) Option {
+	return func(os *options) {
+		os.level = level
+	}
+}
+
+func withLogSwitch(sw *logSwitch) Option {
+	return func(os *options) {
+		os.logSwitch = sw
+	}
+}
+
+// WithSlowThreshold logs only the failed queries, the queries taking at least
+// d and, with WithSampleRate, a sample of the others.
+func WithSlowThreshold(d time.Duration) Option {
+	return func(os *options) {
+		os.slowThreshold = d
+	}
+}
+
+// WithSampleRate logs the given fraction, between 0 and 1, of the successful
+// queries faster than the slow threshold. Failures are always logged.
+func WithSampleRate(rate float64) Option {
+	return func(os *options) {
+		os.sampleRate = rate
+	}
+}
+
+// WithLongTxThreshold logs as warnings the commits and rollbacks of the
+// transactions that lasted at least d since they began.
+func WithLongTxThreshold(d time.Duration) Option {
+	return func(os *options) {
+		os.longTxThreshold = d
+	}
+}
+
+// WithRedaction masks or truncates statement arguments before they are
+// logged. A nil r logs arguments as they are.
+func WithRedaction(r *Redaction) Option {
+	return func(os *options) {
+		os.redaction = r
+	}
+}
+
+// WithInterpolate logs statements with their arguments inlined as literals
+// of the driver's dialect, ready to paste in a database console.
+func WithInterpolate(interpolate bool) Option {
+	return func(os *options) {
+		os.interpolate = interpolate
+	}
+}
+
+// WithCallerSkip ignores the stack frames whose function name starts with
+// one of prefixes, such as "example.com/app/repository.", when reporting
+// the caller of a statement.
+func WithCallerSkip(prefixes ...string) Option {
+	return func(os *options) {
+		os.callerSkip = append(os.callerSkip, prefixes...)
+	}
+}
+
+// WithInterceptors runs interceptors around every statement, after the query
+// log when logging is enabled. See Chain.
+func WithInterceptors(interceptors ...Interceptor) Option {
+	return func(os *options) {
+		os.interceptors = append(os.interceptors, interceptors...)
+	}
+}
+
+// WithQueryStats aggregates the statements of every node of a ClusterDB by
+// Fingerprint, see ClusterDB.TopQueries.
+func WithQueryStats(enable bool) Option {
+	return func(os *options) {
+		os.queryStats = enable
+	}
+}
+
+// WithSQLComment appends to every statement a sqlcommenter comment made of
+// tags, so that the slow logs of the database can be matched with the
+// application. The comment goes to the database only, the events keep the
+// statement as written. Statements with a comment already and prepared
+// statements are left untagged, as the drivers key prepared statements on
+// their text.
+//
+// The tagged statements are never prepared by sqlxcluster, but drivers that
+// cache the statements they prepare, keyed on their text, cache one per
+// distinct comment, so that tags such as a request or trace ID fill the cache
+// and make every statement a miss. Such caches must be disabled: run pgx with
+// default_query_exec_mode=exec or simple_protocol, not the default
+// cache_statement. The MySQL driver caches nothing, though without
+// interpolateParams it prepares and closes every statement with arguments.
+func WithSQLComment(tags ...CommentTag) Option {
+	return func(os *options) {
+		os.commentTags = append(os.commentTags, tags...)
+	}
+}
+
+// WithNPlusOneDetection calls report when a statement runs more than max
+// times with the same fingerprint in a query scope, see WithQueryScope. It is
+// meant for development and tests: every statement of a scope is counted.
+func WithNPlusOneDetection(max int, report func(ctx context.Context, r *NPlusOne)) Option {
+	return func(os *options) {
+		os.nPlusOne = &nPlusOneDetector{max: max, report: report}
+	}
+}
+
+// WithExplain runs the EXPLAIN of the reads slower than the slow threshold on
+// their node, at most once per interval for a fingerprint, and attaches the
+// plan found to the events of the slow reads sharing it. The EXPLAIN runs in
+// the background, with the values of the context of the read but not its
+// cancellation, and the read that ran it is logged once it is done. A failed
+// EXPLAIN is tried again with the next slow read. It supports MySQL,
+// PostgreSQL and SQLite.
+func WithExplain(interval time.Duration) Option {
+	return func(os *options) {
+		os.explainInterval = interval
+	}
+}
+
+func withExplainer(x *explainer) Option {
+	return func(os *options) {
+		os.explainer = x
+	}
+}
+
+func withDriver(driverName string) Option {
+	return func(os *options) {
+		os.driverName = driverName
+	}
+}
+
+func withNode(name string, role Role) Option {
+	return func(os *options) {
+		os.nodeName = name
+		os.nodeRole = role
+	}
+}
+
+// WithOnConnect runs hook once on every new connection of every node opened
+// by OpenClusterDB. A failing hook discards the connection.
+func WithOnConnect(hook ConnectHook) Option {
+	return func(os *options) {
+		os.onConnect = append(os.onConnect, hook)
+	}
+}
+
+func WithPrimaryOnConnect(hook ConnectHook) Option {
+	return func(os *options) {
+		os.onPrimaryConnect = append(os.onPrimaryConnect, hook)
+	}
+}
+
+func WithReplicaOnConnect(hook ConnectHook) Option {
+	return func(os *options) {
+		os.onReplicaConnect = append(os.onReplicaConnect, hook)
+	}
+}
+
+// WithReadFromPrimary lets the primary serve reads alongside the replicas.
+func WithReadFromPrimary(readFromPrimary bool) Option {
+	return func(os *options) {
+		os.readFromPrimary = readFromPrimary
+	}
+}
+
+// WithRouteObserver reports to observer how every read is routed.
+func WithRouteObserver(observer RouteObserver) Option {
+	return func(os *options) {
+		os.routeObserver = observer
+	}
+}
+
+func WithLazyAdd(f func(name string) (DB, error)) Option {
+	return func(os *options) {
+		os.lazyAdd = f
+	}
+}
+
+// WithLazyBackoff sets how long a failed lazy add is remembered. The delay
+// starts at min and doubles on each consecutive failure up to max.
+func WithLazyBackoff(min time.Duration, max time.Duration) Option {
+	return func(os *options) {
+		os.lazyMinBackoff = min
+		os.lazyMaxBackoff = max
+	}
+}
+
+// WithIdleTimeout makes DBManager close lazily added pools that have not been
+// used for d. They are added again on the next Get.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(os *options) {
+		os.idleTimeout = d
+	}
+}
+
+// WithMaxPools caps the number of lazily added pools kept by DBManager,
+// evicting the least recently used ones first.
+func WithMaxPools(n int) Option {
+	return func(os *options) {
+		os.maxPools = n
+	}
+}
+
+// WithResolver sets how DBManager.FromContext maps a request context to a
+// pool name, for example from a tenant ID or region.
+func WithResolver(f func(ctx context.Context) (string, error)) Option {
+	return func(os *options) {
+		os.resolver = f
+	}
+}
@@ -0,0 +1,238 @@
+package sqlxcluster
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var _ driver.Valuer = Secret{}
+
+// Secret wraps a statement argument that must never be logged. The driver
+// receives V unchanged.
+type Secret struct {
+	V interface{}
+}
+
+func (s Secret) Value() (driver.Value, error) {
+	if v, ok := s.V.(driver.Valuer); ok {
+		return v.Value()
+	}
+	return driver.DefaultParameterConverter.ConvertValue(s.V)
+}
+
+func (s Secret) String() string {
+	return defaultMask
+}
+
+const defaultMask = "***"
+
+// Redaction masks statement arguments in query logs. An argument is masked
+// when it is a Secret or any of the listed types, sits at one of Positions,
+// is bound to one of Names, or is compared with or inserted into a column
+// matching Columns. Other strings and []byte longer than MaxLen are cut.
+type Redaction struct {
+	Positions []int
+	Names     []string
+	Columns   *regexp.Regexp
+	Types     []reflect.Type
+	MaxLen    int
+	Mask      string
+}
+
+func (r *Redaction) apply(d dialect, query string, args []interface{}, names []string) []interface{} {
+	if len(args) == 0 {
+		return args
+	}
+	mask := r.Mask
+	if mask == "" {
+		mask = defaultMask
+	}
+	var columns map[int]string
+	if r.Columns != nil {
+		columns = placeholderColumns(d, query)
+	}
+	ls := make([]interface{}, len(args))
+	for i, arg := range args {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		value := arg
+		named, isNamed := arg.(sql.NamedArg)
+		if isNamed {
+			name = named.Name
+			value = named.Value
+		}
+		switch {
+		case r.masked(i, name, value) || r.Columns != nil && columns[i] != "" && r.Columns.MatchString(columns[i]):
+			value = mask
+		default:
+			value = r.truncate(value)
+		}
+		if isNamed {
+			named.Value = value
+			value = named
+		}
+		ls[i] = value
+	}
+	return ls
+}
+
+func (r *Redaction) masked(i int, name string, arg interface{}) bool {
+	if _, ok := arg.(Secret); ok {
+		return true
+	}
+	for _, p := range r.Positions {
+		if p == i {
+			return true
+		}
+	}
+	if name != "" {
+		for _, n := range r.Names {
+			if strings.EqualFold(n, name) {
+				return true
+			}
+		}
+	}
+	if len(r.Types) > 0 && arg != nil {
+		t := reflect.TypeOf(arg)
+		for _, e := range r.Types {
+			if e == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *Redaction) truncate(arg interface{}) interface{} {
+	if r.MaxLen <= 0 {
+		return arg
+	}
+	// Text is cut before the rune at MaxLen, so that the logs stay UTF-8.
+	n := r.MaxLen
+	switch v := arg.(type) {
+	case string:
+		if len(v) > n {
+			for n > 0 && !utf8.RuneStart(v[n]) {
+				n--
+			}
+			return v[:n] + "..."
+		}
+	case []byte:
+		if len(v) > n {
+			if utf8.Valid(v) {
+				for n > 0 && !utf8.RuneStart(v[n]) {
+					n--
+				}
+			}
+			return v[:n]
+		}
+	}
+	return arg
+}
+
+// placeholderColumns guesses the column of each placeholder, by argument index.
+func placeholderColumns(d dialect, query string) map[int]string {
+	tokens := significant(lexSQL(d, query))
+	columns := make(map[int]string)
+
+	var insertColumns []string
+	inValues := false
+	depth, pos := 0, 0
+	seq := 0
+	for i, t := range tokens {
+		switch {
+		case t.is("into") && i > 0 && (tokens[i-1].is("insert") || tokens[i-1].is("replace") || tokens[i-1].is("ignore")):
+			insertColumns = insertColumnList(tokens[i+1:])
+		case t.is("values") || t.is("value"):
+			inValues = len(insertColumns) > 0
+			depth = 0
+		case t.text == "(" && t.kind == tokPunct:
+			depth++
+			if depth == 1 {
+				pos = 0
+			}
+		case t.text == ")" && t.kind == tokPunct:
+			depth--
+		case t.text == "," && t.kind == tokPunct && depth == 1:
+			pos++
+		case t.kind == tokPlaceholder:
+			index := placeholderIndex(t.text, seq)
+			seq++
+			if inValues && depth == 1 && pos < len(insertColumns) {
+				columns[index] = insertColumns[pos]
+			} else if column := comparedColumn(tokens[:i]); column != "" {
+				columns[index] = column
+			}
+		}
+	}
+	return columns
+}
+
+func insertColumnList(tokens []token) []string {
+	i := 0
+	for i < len(tokens) && (tokens[i].kind == tokIdent || tokens[i].kind == tokQuotedIdent || tokens[i].text == ".") {
+		i++
+	}
+	if i >= len(tokens) || tokens[i].text != "(" {
+		return nil
+	}
+	var ls []string
+	for _, t := range tokens[i+1:] {
+		switch {
+		case t.text == ")":
+			return ls
+		case t.kind == tokIdent || t.kind == tokQuotedIdent:
+			ls = append(ls, unquoteIdent(t.text))
+		}
+	}
+	return nil
+}
+
+func comparedColumn(before []token) string {
+	j := len(before) - 1
+	for j >= 0 && (before[j].text == "," || before[j].kind == tokPlaceholder) {
+		j--
+	}
+	if j >= 0 && before[j].text == "(" {
+		j--
+		if j < 0 || !before[j].is("in") {
+			return ""
+		}
+		j--
+	} else if j >= 0 && (before[j].kind == tokOperator || before[j].is("like") || before[j].is("ilike")) {
+		j--
+	} else {
+		return ""
+	}
+	if j >= 0 && before[j].is("not") {
+		j--
+	}
+	if j >= 0 && (before[j].kind == tokIdent || before[j].kind == tokQuotedIdent) {
+		return unquoteIdent(before[j].text)
+	}
+	return ""
+}
+
+// placeholderIndex returns the argument index of a placeholder.
+func placeholderIndex(text string, seq int) int {
+	var digits string
+	switch {
+	case strings.HasPrefix(text, "$"):
+		digits = text[1:]
+	case len(text) > 2 && (text[:2] == "@p" || text[:2] == "@P"):
+		digits = text[2:]
+	}
+	if digits != "" {
+		if n, err := strconv.Atoi(digits); err == nil && n > 0 {
+			return n - 1
+		}
+	}
+	return seq
+}
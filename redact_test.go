@@ -0,0 +1,63 @@
+package sqlxcluster
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestPlaceholderColumns(t *testing.T) {
+	tests := []struct {
+		query string
+		want  map[int]string
+	}{
+		{"select * from user where name = ? and u.password=?", map[int]string{0: "name", 1: "password"}},
+		{"select * from user where id in (?, ?) and email like ?", map[int]string{0: "id", 1: "id", 2: "email"}},
+		{"insert into `user` (`name`, token) values (?, ?), (?, ?)", map[int]string{0: "name", 1: "token", 2: "name", 3: "token"}},
+		{`update "user" set "token" = $2 where id = $1`, map[int]string{1: "token", 0: "id"}},
+		{"select * from t where a = 'x = ?' and b = @p1", map[int]string{0: "b"}},
+	}
+	for _, tt := range tests {
+		if got := placeholderColumns(dialectGeneric, tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+type apiKey string
+
+func TestRedaction(t *testing.T) {
+	r := &Redaction{
+		Positions: []int{0},
+		Names:     []string{"pin"},
+		Columns:   regexp.MustCompile(`(?i)password|token`),
+		Types:     []reflect.Type{reflect.TypeOf(apiKey(""))},
+		MaxLen:    4,
+	}
+	query := "update user set password = ?, note = ? where id = ? and key = ? and card = ? and pin = ?"
+	args := []interface{}{"a", "a long note", Secret{V: 7}, apiKey("k"), []byte("abcdef"), sql.Named("pin", 1234)}
+	got := fmt.Sprint(r.apply(dialectGeneric, query, args, nil))
+	want := fmt.Sprint([]interface{}{"***", "a lo...", "***", "***", []byte("abcd"), sql.Named("pin", "***")})
+	if got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	r = &Redaction{Columns: r.Columns}
+	if got := fmt.Sprint(r.apply(dialectGeneric, "update user set id = ?, token = ?", []interface{}{1, 2}, nil)); got != "[1 ***]" {
+		t.Fatalf("unexpected redaction by column: %s", got)
+	}
+
+	// In PostgreSQL # is an operator, not the start of a comment.
+	query = "update user set flags = flags # 4, token = $1"
+	if got := fmt.Sprint(r.apply(dialectPostgres, query, []interface{}{"t"}, nil)); got != "[***]" {
+		t.Fatalf("unexpected redaction after #: %s", got)
+	}
+
+	r = &Redaction{MaxLen: 4}
+	got = fmt.Sprint(r.apply(dialectGeneric, "select ?, ?", []interface{}{"añño", []byte("añño")}, nil))
+	if got != fmt.Sprint([]interface{}{"añ...", []byte("añ")}) {
+		t.Fatalf("expected values cut at a rune boundary, got %s", got)
+	}
+}
@@ -0,0 +1,130 @@
+package sqlxcluster
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// bare is the chain of the statements of the pool, under every wrapper.
+var bare = newChain(&options{}, nil)
+
+// rows reports the rows returned by f once they are closed.
+func (c *chain) rows(ctx context.Context, e *QueryEvent, f func(ctx context.Context) (*Rows, error)) (*Rows, error) {
+	if c.idle() {
+		return f(ctx)
+	}
+	ctx, n, err := c.before(ctx, e)
+	if err == nil {
+		var rows *Rows
+		if rows, err = f(ctx); err == nil && rows != nil {
+			e.Caller = findCaller(c.skip)
+			return &Rows{Rows: rows.Rows, next: rows, c: c, ctx: ctx, e: e, n: n}, nil
+		}
+	}
+	e.Err = err
+	c.after(ctx, e, n)
+	return nil, err
+}
+
+func newRows(rows *sqlx.Rows, err error) (*Rows, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &Rows{Rows: rows}, nil
+}
+
+// Rows reports its statement to the interceptors once closed, or once Next
+// returned false, with the number of rows read, the time spent until then
+// and the first error met, scan errors included.
+type Rows struct {
+	*sqlx.Rows
+	next   *Rows  // of the wrapped DB, nil for the rows of the pool
+	c      *chain // nil when not reported
+	ctx    context.Context
+	e      *QueryEvent
+	n      int
+	rows   int64
+	err    error
+	closed bool
+}
+
+func (r *Rows) Next() bool {
+	var ok bool
+	if r.next != nil {
+		ok = r.next.Next()
+	} else {
+		ok = r.Rows.Next()
+	}
+	if ok {
+		r.rows++
+		return true
+	}
+	r.report(nil)
+	return false
+}
+
+func (r *Rows) Scan(dest ...interface{}) error {
+	if r.next != nil {
+		return r.scanned(r.next.Scan(dest...))
+	}
+	return r.scanned(r.Rows.Scan(dest...))
+}
+
+func (r *Rows) StructScan(dest interface{}) error {
+	if r.next != nil {
+		return r.scanned(r.next.StructScan(dest))
+	}
+	return r.scanned(r.Rows.StructScan(dest))
+}
+
+func (r *Rows) MapScan(dest map[string]interface{}) error {
+	if r.next != nil {
+		return r.scanned(r.next.MapScan(dest))
+	}
+	return r.scanned(r.Rows.MapScan(dest))
+}
+
+func (r *Rows) SliceScan() ([]interface{}, error) {
+	if r.next != nil {
+		values, err := r.next.SliceScan()
+		return values, r.scanned(err)
+	}
+	values, err := r.Rows.SliceScan()
+	return values, r.scanned(err)
+}
+
+func (r *Rows) Close() error {
+	var err error
+	if r.next != nil {
+		err = r.next.Close()
+	} else {
+		err = r.Rows.Close()
+	}
+	r.report(err)
+	return err
+}
+
+func (r *Rows) scanned(err error) error {
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	return err
+}
+
+func (r *Rows) report(err error) {
+	if r.c == nil || r.closed {
+		return
+	}
+	r.closed = true
+	if r.err != nil {
+		err = r.err
+	} else if rerr := r.Rows.Err(); rerr != nil {
+		err = rerr
+	}
+	r.e.Rows = r.rows
+	r.e.Err = err
+	r.e.Duration = time.Since(r.e.Time)
+	r.c.after(r.ctx, r.e, r.n)
+}
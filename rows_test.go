@@ -0,0 +1,61 @@
+package sqlxcluster
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/go-comm/sqlxcluster/internal/fakedriver"
+)
+
+func TestRowsOverAnyPool(t *testing.T) {
+	d := fakedriver.New()
+	pool, err := sql.Open(d.Name, "primary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []*QueryEvent
+	db := NewLoggedDB(NewDB(pool, d.Name), WithEnableLog(true),
+		WithLogger(QueryLoggerFunc(func(ctx context.Context, e *QueryEvent) {
+			events = append(events, e)
+		})))
+	defer db.Close()
+	ctx := context.Background()
+
+	rows, err := db.QueryContext(ctx, "select 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	if len(events) != 1 || events[0].Rows != 3 || events[0].Err != nil || events[0].Duration <= 0 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	rows.Close()
+	if len(events) != 1 {
+		t.Fatal("expected the rows to be logged once")
+	}
+
+	events = nil
+	rows, err = db.Queryx("select 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	for rows.Next() {
+		rows.Scan(&s, &s)
+	}
+	rows.Close()
+	if len(events) != 1 || events[0].Rows != 2 || events[0].Err == nil {
+		t.Fatalf("expected the scan error to be logged, got %+v", events)
+	}
+
+	denied := fmt.Errorf("denied")
+	chained := Chain(db, InterceptorFuncs{BeforeFunc: func(ctx context.Context, e *QueryEvent) (context.Context, error) {
+		return ctx, denied
+	}})
+	if _, err := chained.QueryxContext(ctx, "select 2"); err != denied {
+		t.Fatalf("expected the interceptor error, got %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+package sqlxcluster
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fingerprint normalizes query so that the statements differing only by
+// their literals, placeholders, comments, spacing or the length of their IN
+// lists share it: literals and placeholders become ?, IN lists (...), and
+// keywords and identifiers are lowercased. The statistics fingerprint the
+// statements with the comments of the dialect of their driver.
+func Fingerprint(query string) string {
+	return fingerprint(dialectGeneric, query)
+}
+
+func fingerprint(d dialect, query string) string {
+	var b strings.Builder
+	tokens := lexSQL(d, query)
+	space := false
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if t.kind == tokSpace || t.kind == tokComment {
+			space = b.Len() > 0
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		switch t.kind {
+		case tokString, tokNumber, tokPlaceholder:
+			b.WriteByte('?')
+		case tokIdent:
+			b.WriteString(strings.ToLower(t.text))
+		default:
+			b.WriteString(t.text)
+		}
+		if t.is("in") {
+			if n := inList(tokens[i+1:]); n > 0 {
+				b.WriteString(" (...)")
+				i += n
+			}
+		}
+	}
+	return b.String()
+}
+
+// inList returns the length of the list of literals tokens starts with, or 0.
+func inList(tokens []token) int {
+	i := 0
+	for i < len(tokens) && (tokens[i].kind == tokSpace || tokens[i].kind == tokComment) {
+		i++
+	}
+	if i == len(tokens) || tokens[i].kind != tokPunct || tokens[i].text != "(" {
+		return 0
+	}
+	values, value := 0, true
+	for i++; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.kind == tokSpace || t.kind == tokComment:
+		case value && (t.kind == tokString || t.kind == tokNumber || t.kind == tokPlaceholder):
+			values++
+			value = false
+		case value && t.kind == tokOperator && t.text == "-":
+		case !value && t.text == ",":
+			value = true
+		case !value && t.text == ")" && values > 0:
+			return i + 1
+		default:
+			return 0
+		}
+	}
+	return 0
+}
+
+// OrderBy is the order of TopQueries.
+type OrderBy int
+
+const (
+	OrderByTotal OrderBy = iota
+	OrderByCalls
+	OrderByMean
+	OrderByP99
+	OrderByErrors
+	OrderByRows
+)
+
+// QueryStat aggregates the statements sharing a fingerprint.
+type QueryStat struct {
+	Fingerprint string
+	Query       string // first statement seen
+	Calls       int64
+	Errors      int64
+	Rows        int64 // rows affected and rows read
+	Total       time.Duration
+	Mean        time.Duration
+	P99         time.Duration // within 10%
+	Max         time.Duration
+}
+
+// maxQueryStats bounds the fingerprints followed.
+const maxQueryStats = 5000
+
+// Durations are counted in buckets growing by 10% from a microsecond, up to
+// more than 3 minutes.
+const (
+	statBuckets = 200
+	statGrowth  = 1.1
+)
+
+type queryStat struct {
+	query   string
+	calls   int64
+	errors  int64
+	rows    int64
+	total   time.Duration
+	max     time.Duration
+	buckets [statBuckets]uint32
+}
+
+func statBucket(d time.Duration) int {
+	if d <= time.Microsecond {
+		return 0
+	}
+	i := int(math.Log(float64(d)/float64(time.Microsecond)) / math.Log(statGrowth))
+	if i >= statBuckets {
+		return statBuckets - 1
+	}
+	return i
+}
+
+func (s *queryStat) p99() time.Duration {
+	rank := int64(math.Ceil(float64(s.calls) * 0.99))
+	var n int64
+	for i, c := range s.buckets {
+		n += int64(c)
+		if n >= rank {
+			d := time.Duration(float64(time.Microsecond) * math.Pow(statGrowth, float64(i+1)))
+			if d > s.max {
+				d = s.max
+			}
+			return d
+		}
+	}
+	return s.max
+}
+
+// queryStats is the interceptor of WithQueryStats.
+type queryStats struct {
+	mutex sync.Mutex
+	stats map[string]*queryStat
+}
+
+func newQueryStats() *queryStats {
+	return &queryStats{stats: make(map[string]*queryStat)}
+}
+
+func (qs *queryStats) Before(ctx context.Context, e *QueryEvent) (context.Context, error) {
+	return ctx, nil
+}
+
+func (qs *queryStats) After(ctx context.Context, e *QueryEvent) {
+	switch {
+	case e.Op == OpPrepare, e.Op == OpPrepareNamed:
+		return
+	}
+	fingerprint := fingerprint(dialectOf(e.Driver), e.Query)
+	var rows int64
+	if e.RowsAffected > 0 {
+		rows += e.RowsAffected
+	}
+	if e.Rows > 0 {
+		rows += e.Rows
+	}
+
+	qs.mutex.Lock()
+	defer qs.mutex.Unlock()
+	s, ok := qs.stats[fingerprint]
+	if !ok {
+		if len(qs.stats) >= maxQueryStats {
+			return
+		}
+		s = &queryStat{query: e.Query}
+		qs.stats[fingerprint] = s
+	}
+	s.calls++
+	if e.Failed() {
+		s.errors++
+	}
+	s.rows += rows
+	s.total += e.Duration
+	if e.Duration > s.max {
+		s.max = e.Duration
+	}
+	s.buckets[statBucket(e.Duration)]++
+}
+
+func (qs *queryStats) top(n int, orderBy OrderBy) []QueryStat {
+	qs.mutex.Lock()
+	ls := make([]QueryStat, 0, len(qs.stats))
+	for fingerprint, s := range qs.stats {
+		ls = append(ls, QueryStat{
+			Fingerprint: fingerprint,
+			Query:       s.query,
+			Calls:       s.calls,
+			Errors:      s.errors,
+			Rows:        s.rows,
+			Total:       s.total,
+			Mean:        s.total / time.Duration(s.calls),
+			P99:         s.p99(),
+			Max:         s.max,
+		})
+	}
+	qs.mutex.Unlock()
+
+	key := func(s *QueryStat) int64 {
+		switch orderBy {
+		case OrderByCalls:
+			return s.Calls
+		case OrderByMean:
+			return int64(s.Mean)
+		case OrderByP99:
+			return int64(s.P99)
+		case OrderByErrors:
+			return s.Errors
+		case OrderByRows:
+			return s.Rows
+		}
+		return int64(s.Total)
+	}
+	sort.Slice(ls, func(i, j int) bool {
+		if ki, kj := key(&ls[i]), key(&ls[j]); ki != kj {
+			return ki > kj
+		}
+		return ls[i].Fingerprint < ls[j].Fingerprint
+	})
+	if n > 0 && n < len(ls) {
+		ls = ls[:n]
+	}
+	return ls
+}
+
+func (qs *queryStats) reset() {
+	qs.mutex.Lock()
+	qs.stats = make(map[string]*queryStat)
+	qs.mutex.Unlock()
+}
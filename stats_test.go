@@ -0,0 +1,82 @@
+package sqlxcluster
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/go-comm/sqlxcluster/internal/fakedriver"
+)
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT * FROM users WHERE id = 42", "select * from users where id = ?"},
+		{"select *\n  from users -- by id\n where id = $1", "select * from users where id = ?"},
+		{"select name from users where email = 'bob@example.com' and age > 1.5e3", "select name from users where email = ? and age > ?"},
+		{"select * from t where id IN (1, 2, -3) and a in(?)", "select * from t where id in (...) and a in (...)"},
+		{"select * from t where id in (select id from u)", "select * from t where id in (select id from u)"},
+		{`update "Users" set name = :name /* app */ where id = @id`, `update "Users" set name = ? where id = ?`},
+		{"select a # 1 from t", "select a # ? from t"},
+	}
+	for _, test := range tests {
+		if got := Fingerprint(test.query); got != test.want {
+			t.Errorf("Fingerprint(%q) = %q, want %q", test.query, got, test.want)
+		}
+	}
+	if got := fingerprint(dialectMySQL, "select a # by id\nfrom t"); got != "select a from t" {
+		t.Errorf("expected a MySQL comment, got %q", got)
+	}
+}
+
+func TestTopQueries(t *testing.T) {
+	d := fakedriver.New()
+	c := OpenClusterDB(d.Name, d.Connector("primary"), []driver.Connector{d.Connector("replica")}, WithQueryStats(true))
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		c.Exec("update t set a = ? where id in (?, ?)", i, 1, 2)
+	}
+	c.Exec("update t set a = 1 where id in (3)")
+	c.Exec("fail 1")
+	rows, _ := c.Queryx("select 5")
+	for rows.Next() {
+	}
+	rows.Close()
+
+	top := c.TopQueries(0, OrderByCalls)
+	if len(top) != 3 {
+		t.Fatalf("expected 3 fingerprints, got %+v", top)
+	}
+	update := top[0]
+	if update.Fingerprint != "update t set a = ? where id in (...)" || update.Calls != 4 || update.Rows != 4 ||
+		update.Query != "update t set a = ? where id in (?, ?)" || update.Mean != update.Total/4 || update.P99 > update.Max {
+		t.Fatalf("unexpected stat %+v", update)
+	}
+	if top := c.TopQueries(1, OrderByRows); len(top) != 1 || top[0].Fingerprint != "select ?" || top[0].Rows != 5 {
+		t.Fatalf("unexpected top queries %+v", top)
+	}
+	if top := c.TopQueries(1, OrderByErrors); top[0].Fingerprint != "fail ?" || top[0].Errors != 1 {
+		t.Fatalf("unexpected top queries %+v", top)
+	}
+
+	c.ResetQueryStats()
+	if top := c.TopQueries(0, OrderByTotal); len(top) != 0 {
+		t.Fatalf("expected no stats after reset, got %+v", top)
+	}
+}
+
+func TestQueryStatP99(t *testing.T) {
+	var s queryStat
+	for i := 1; i <= 100; i++ {
+		d := time.Duration(i) * time.Millisecond
+		s.calls++
+		s.max = d
+		s.buckets[statBucket(d)]++
+	}
+	if p99 := s.p99(); p99 < 99*time.Millisecond || p99 > 100*time.Millisecond {
+		t.Fatalf("unexpected p99 %s", p99)
+	}
+}
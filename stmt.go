@@ -0,0 +1,391 @@
+package sqlxcluster
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Stmt is a prepared statement whose executions go through the interceptors
+// of the DB, Tx or Conn it was prepared on. They cannot be rewritten.
+type Stmt struct {
+	*sqlx.Stmt
+	c     *command
+	query string
+	next  *Stmt // of the wrapped DB, nil for the statement of the pool
+}
+
+func (s *Stmt) Exec(args ...interface{}) (sql.Result, error) {
+	return s.ExecContext(context.Background(), args...)
+}
+
+func (s *Stmt) ExecContext(ctx context.Context, args ...interface{}) (d sql.Result, err error) {
+	e := s.c.event(OpExec, s.query, args)
+	err = s.c.run(withPrepared(ctx), e, func(ctx context.Context) error {
+		if s.next != nil {
+			d, err = s.next.ExecContext(ctx, args...)
+		} else {
+			d, err = s.Stmt.ExecContext(ctx, args...)
+		}
+		e.Result = d
+		return err
+	})
+	return
+}
+
+func (s *Stmt) MustExec(args ...interface{}) sql.Result {
+	return s.MustExecContext(context.Background(), args...)
+}
+
+func (s *Stmt) MustExecContext(ctx context.Context, args ...interface{}) sql.Result {
+	d, err := s.ExecContext(ctx, args...)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func (s *Stmt) Query(args ...interface{}) (*Rows, error) {
+	return s.QueryxContext(context.Background(), args...)
+}
+
+func (s *Stmt) QueryContext(ctx context.Context, args ...interface{}) (*Rows, error) {
+	return s.QueryxContext(ctx, args...)
+}
+
+func (s *Stmt) Queryx(args ...interface{}) (*Rows, error) {
+	return s.QueryxContext(context.Background(), args...)
+}
+
+func (s *Stmt) QueryxContext(ctx context.Context, args ...interface{}) (*Rows, error) {
+	e := s.c.event(OpQuery, s.query, args)
+	return s.c.rows(withPrepared(ctx), e, func(ctx context.Context) (*Rows, error) {
+		if s.next != nil {
+			return s.next.QueryxContext(ctx, args...)
+		}
+		return newRows(s.Stmt.QueryxContext(ctx, args...))
+	})
+}
+
+func (s *Stmt) QueryRow(args ...interface{}) *sql.Row {
+	return s.QueryRowContext(context.Background(), args...)
+}
+
+func (s *Stmt) QueryRowContext(ctx context.Context, args ...interface{}) (d *sql.Row) {
+	e := s.c.event(OpQueryRow, s.query, args)
+	err := s.c.run(withPrepared(ctx), e, func(ctx context.Context) error {
+		if s.next != nil {
+			d = s.next.QueryRowContext(ctx, args...)
+		} else {
+			d = s.Stmt.QueryRowContext(ctx, args...)
+		}
+		return d.Err()
+	})
+	if d == nil {
+		d = failedRow(err)
+	}
+	return
+}
+
+func (s *Stmt) QueryRowx(args ...interface{}) *sqlx.Row {
+	return s.QueryRowxContext(context.Background(), args...)
+}
+
+func (s *Stmt) QueryRowxContext(ctx context.Context, args ...interface{}) (d *sqlx.Row) {
+	e := s.c.event(OpQueryRow, s.query, args)
+	err := s.c.run(withPrepared(ctx), e, func(ctx context.Context) error {
+		if s.next != nil {
+			d = s.next.QueryRowxContext(ctx, args...)
+		} else {
+			d = s.Stmt.QueryRowxContext(ctx, args...)
+		}
+		return d.Err()
+	})
+	if d == nil {
+		d = failedRowx(err)
+	}
+	return
+}
+
+func (s *Stmt) Get(dest interface{}, args ...interface{}) error {
+	return s.GetContext(context.Background(), dest, args...)
+}
+
+func (s *Stmt) GetContext(ctx context.Context, dest interface{}, args ...interface{}) error {
+	e := s.c.event(OpGet, s.query, args)
+	return s.c.run(withPrepared(ctx), e, func(ctx context.Context) error {
+		if s.next != nil {
+			return s.next.GetContext(ctx, dest, args...)
+		}
+		return s.Stmt.GetContext(ctx, dest, args...)
+	})
+}
+
+func (s *Stmt) Select(dest interface{}, args ...interface{}) error {
+	return s.SelectContext(context.Background(), dest, args...)
+}
+
+func (s *Stmt) SelectContext(ctx context.Context, dest interface{}, args ...interface{}) error {
+	e := s.c.event(OpSelect, s.query, args)
+	return s.c.run(withPrepared(ctx), e, func(ctx context.Context) error {
+		if s.next != nil {
+			return s.next.SelectContext(ctx, dest, args...)
+		}
+		return s.Stmt.SelectContext(ctx, dest, args...)
+	})
+}
+
+// NamedStmt is Stmt for named statements.
+type NamedStmt struct {
+	*sqlx.NamedStmt
+	c     *command
+	query string
+	next  *NamedStmt
+}
+
+func (s *NamedStmt) event(op Op, arg interface{}) *QueryEvent {
+	return s.c.namedEvent(op, s.query, arg)
+}
+
+func (s *NamedStmt) Exec(arg interface{}) (sql.Result, error) {
+	return s.ExecContext(context.Background(), arg)
+}
+
+func (s *NamedStmt) ExecContext(ctx context.Context, arg interface{}) (d sql.Result, err error) {
+	e := s.event(OpNamedExec, arg)
+	err = s.c.run(withPrepared(ctx), e, func(ctx context.Context) error {
+		if s.next != nil {
+			d, err = s.next.ExecContext(ctx, arg)
+		} else {
+			d, err = s.NamedStmt.ExecContext(ctx, arg)
+		}
+		e.Result = d
+		return err
+	})
+	return
+}
+
+func (s *NamedStmt) MustExec(arg interface{}) sql.Result {
+	return s.MustExecContext(context.Background(), arg)
+}
+
+func (s *NamedStmt) MustExecContext(ctx context.Context, arg interface{}) sql.Result {
+	d, err := s.ExecContext(ctx, arg)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func (s *NamedStmt) Query(arg interface{}) (*Rows, error) {
+	return s.QueryxContext(context.Background(), arg)
+}
+
+func (s *NamedStmt) QueryContext(ctx context.Context, arg interface{}) (*Rows, error) {
+	return s.QueryxContext(ctx, arg)
+}
+
+func (s *NamedStmt) Queryx(arg interface{}) (*Rows, error) {
+	return s.QueryxContext(context.Background(), arg)
+}
+
+func (s *NamedStmt) QueryxContext(ctx context.Context, arg interface{}) (*Rows, error) {
+	e := s.event(OpNamedQuery, arg)
+	return s.c.rows(withPrepared(ctx), e, func(ctx context.Context) (*Rows, error) {
+		if s.next != nil {
+			return s.next.QueryxContext(ctx, arg)
+		}
+		return newRows(s.NamedStmt.QueryxContext(ctx, arg))
+	})
+}
+
+func (s *NamedStmt) QueryRow(arg interface{}) *sqlx.Row {
+	return s.QueryRowxContext(context.Background(), arg)
+}
+
+func (s *NamedStmt) QueryRowContext(ctx context.Context, arg interface{}) *sqlx.Row {
+	return s.QueryRowxContext(ctx, arg)
+}
+
+func (s *NamedStmt) QueryRowx(arg interface{}) *sqlx.Row {
+	return s.QueryRowxContext(context.Background(), arg)
+}
+
+func (s *NamedStmt) QueryRowxContext(ctx context.Context, arg interface{}) (d *sqlx.Row) {
+	e := s.event(OpQueryRow, arg)
+	err := s.c.run(withPrepared(ctx), e, func(ctx context.Context) error {
+		if s.next != nil {
+			d = s.next.QueryRowxContext(ctx, arg)
+		} else {
+			d = s.NamedStmt.QueryRowxContext(ctx, arg)
+		}
+		return d.Err()
+	})
+	if d == nil {
+		d = failedRowx(err)
+	}
+	return
+}
+
+func (s *NamedStmt) Get(dest interface{}, arg interface{}) error {
+	return s.GetContext(context.Background(), dest, arg)
+}
+
+func (s *NamedStmt) GetContext(ctx context.Context, dest interface{}, arg interface{}) error {
+	e := s.event(OpGet, arg)
+	return s.c.run(withPrepared(ctx), e, func(ctx context.Context) error {
+		if s.next != nil {
+			return s.next.GetContext(ctx, dest, arg)
+		}
+		return s.NamedStmt.GetContext(ctx, dest, arg)
+	})
+}
+
+func (s *NamedStmt) Select(dest interface{}, arg interface{}) error {
+	return s.SelectContext(context.Background(), dest, arg)
+}
+
+func (s *NamedStmt) SelectContext(ctx context.Context, dest interface{}, arg interface{}) error {
+	e := s.event(OpSelect, arg)
+	return s.c.run(withPrepared(ctx), e, func(ctx context.Context) error {
+		if s.next != nil {
+			return s.next.SelectContext(ctx, dest, arg)
+		}
+		return s.NamedStmt.SelectContext(ctx, dest, arg)
+	})
+}
+
+// Conn is a connection whose statements go through the interceptors of the
+// DB it was taken from. The comments of WithSQLComment are added by the
+// connection of the pool, under every wrapper.
+type Conn struct {
+	*sqlx.Conn
+	c    *chain
+	next *Conn // of the wrapped DB, nil for the connection of the pool
+}
+
+func (c *Conn) ExecContext(ctx context.Context, query string, args ...interface{}) (d sql.Result, err error) {
+	e := c.c.event(OpExec, query, args)
+	err = c.c.run(ctx, e, func(ctx context.Context) error {
+		if c.next != nil {
+			d, err = c.next.ExecContext(ctx, e.Query, e.Args...)
+		} else {
+			d, err = c.Conn.ExecContext(ctx, tagged(ctx, e.Query), e.Args...)
+		}
+		e.Result = d
+		return err
+	})
+	return
+}
+
+func (c *Conn) QueryContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return c.QueryxContext(ctx, query, args...)
+}
+
+func (c *Conn) QueryxContext(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	e := c.c.event(OpQuery, query, args)
+	return c.c.rows(ctx, e, func(ctx context.Context) (*Rows, error) {
+		if c.next != nil {
+			return c.next.QueryxContext(ctx, e.Query, e.Args...)
+		}
+		return newRows(c.Conn.QueryxContext(ctx, tagged(ctx, e.Query), e.Args...))
+	})
+}
+
+func (c *Conn) QueryRowContext(ctx context.Context, query string, args ...interface{}) (d *sql.Row) {
+	e := c.c.event(OpQueryRow, query, args)
+	err := c.c.run(ctx, e, func(ctx context.Context) error {
+		if c.next != nil {
+			d = c.next.QueryRowContext(ctx, e.Query, e.Args...)
+		} else {
+			d = c.Conn.QueryRowContext(ctx, tagged(ctx, e.Query), e.Args...)
+		}
+		return d.Err()
+	})
+	if d == nil {
+		d = failedRow(err)
+	}
+	return
+}
+
+func (c *Conn) QueryRowxContext(ctx context.Context, query string, args ...interface{}) (d *sqlx.Row) {
+	e := c.c.event(OpQueryRow, query, args)
+	err := c.c.run(ctx, e, func(ctx context.Context) error {
+		if c.next != nil {
+			d = c.next.QueryRowxContext(ctx, e.Query, e.Args...)
+		} else {
+			d = c.Conn.QueryRowxContext(ctx, tagged(ctx, e.Query), e.Args...)
+		}
+		return d.Err()
+	})
+	if d == nil {
+		d = failedRowx(err)
+	}
+	return
+}
+
+func (c *Conn) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	e := c.c.event(OpGet, query, args)
+	return c.c.run(ctx, e, func(ctx context.Context) error {
+		if c.next != nil {
+			return c.next.GetContext(ctx, dest, e.Query, e.Args...)
+		}
+		return c.Conn.GetContext(ctx, dest, tagged(ctx, e.Query), e.Args...)
+	})
+}
+
+func (c *Conn) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	e := c.c.event(OpSelect, query, args)
+	return c.c.run(ctx, e, func(ctx context.Context) error {
+		if c.next != nil {
+			return c.next.SelectContext(ctx, dest, e.Query, e.Args...)
+		}
+		return c.Conn.SelectContext(ctx, dest, tagged(ctx, e.Query), e.Args...)
+	})
+}
+
+func (c *Conn) PrepareContext(ctx context.Context, query string) (*Stmt, error) {
+	return c.PreparexContext(ctx, query)
+}
+
+func (c *Conn) PreparexContext(ctx context.Context, query string) (d *Stmt, err error) {
+	e := c.c.event(OpPrepare, query, nil)
+	var s *sqlx.Stmt
+	err = c.c.run(ctx, e, func(ctx context.Context) error {
+		if c.next != nil {
+			d, err = c.next.PreparexContext(ctx, e.Query)
+		} else {
+			s, err = c.Conn.PreparexContext(ctx, e.Query)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return &Stmt{Stmt: s, c: &command{chain: c.c}, query: query}, nil
+	}
+	return &Stmt{Stmt: d.Stmt, c: &command{chain: c.c}, query: query, next: d}, nil
+}
+
+func (c *Conn) BeginTx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	return c.BeginTxx(ctx, opts)
+}
+
+func (c *Conn) BeginTxx(ctx context.Context, opts *sql.TxOptions) (Tx, error) {
+	if c.next == nil {
+		tx, err := c.Conn.BeginTxx(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		return &wrappedTx{Tx: tx}, nil
+	}
+	tx, err := c.c.begin(ctx, func(ctx context.Context) (Tx, error) {
+		return c.next.BeginTxx(ctx, opts)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
@@ -0,0 +1,156 @@
+package sqlxcluster
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/go-comm/sqlxcluster/internal/fakedriver"
+)
+
+func TestWrappersOverAnyPool(t *testing.T) {
+	d := fakedriver.New()
+	pool, err := sql.Open(d.Name, "primary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []*QueryEvent
+	db := NewLoggedDB(NewDB(pool, d.Name), WithEnableLog(true),
+		WithLogger(QueryLoggerFunc(func(ctx context.Context, e *QueryEvent) {
+			events = append(events, e)
+		})))
+	defer db.Close()
+	ctx := context.Background()
+
+	stmt, err := db.PreparexContext(ctx, "update t set a = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt.Exec(1)
+	stmt.Close()
+	if len(events) != 2 || events[1].Op != OpExec || events[1].Query != "update t set a = ?" || events[1].RowsAffected != 1 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	events = nil
+	nstmt, err := db.PrepareNamedContext(ctx, "update t set a = :a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nstmt.Exec(map[string]interface{}{"a": 3})
+	nstmt.Close()
+	if len(events) != 2 || events[1].Op != OpNamedExec || events[1].Query != "update t set a = :a" || events[1].ArgNames[0] != "a" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	events = nil
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.ExecContext(ctx, "update t set b = 1")
+	crows, err := conn.QueryxContext(ctx, "select 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	crows.Close()
+	cstmt, err := conn.PreparexContext(ctx, "update t set c = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cstmt.ExecContext(ctx, 2)
+	conn.Close()
+	if len(events) != 4 || events[0].Op != OpExec || events[1].Rows != 0 || events[2].Op != OpPrepare || events[3].Args[0] != 2 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	events = nil
+	tx, err := Begin(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tstmt, err := tx.PreparexContext(ctx, "update t set b = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tstmt.Exec(4)
+	tx.Commit()
+	if len(events) != 4 || events[2].Op != OpExec || events[2].TxID == "" || events[2].TxID != events[0].TxID || events[3].Statements != 2 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	// The statements of a chained DB go through its interceptors, then
+	// through those of the DB it wraps.
+	events = nil
+	var chained []Op
+	cdb := Chain(db, InterceptorFuncs{AfterFunc: func(ctx context.Context, e *QueryEvent) {
+		chained = append(chained, e.Op)
+	}})
+	cstmt, err = cdb.Preparex("update t set d = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cstmt.Exec(5)
+	conn, err = cdb.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err = conn.BeginTxx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Exec("update t set e = 1")
+	tx.Commit()
+	conn.Close()
+	if len(chained) != 5 || len(events) != 5 || chained[1] != OpExec || events[4].Op != OpCommit || events[3].TxID != events[4].TxID {
+		t.Fatalf("unexpected events %v %+v", chained, events)
+	}
+}
+
+func TestWrappersOverClusterDB(t *testing.T) {
+	d := fakedriver.New()
+	var events []*QueryEvent
+	c := OpenClusterDB(d.Name, d.Connector("primary"), nil, WithEnableLog(true),
+		WithLogger(QueryLoggerFunc(func(ctx context.Context, e *QueryEvent) {
+			events = append(events, e)
+		})))
+	defer c.Close()
+	ctx := context.Background()
+
+	stmt, err := c.PreparexContext(ctx, "select 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ns []int
+	stmt.Select(&ns)
+	rows, err := stmt.Queryx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	if len(events) != 3 || events[1].Op != OpSelect || events[2].Op != OpQuery || events[2].Rows != 0 {
+		t.Fatalf("expected the statements to be logged once, got %+v", events)
+	}
+}
+
+func TestConnRaw(t *testing.T) {
+	d := fakedriver.New()
+	c := OpenClusterDB(d.Name, d.Connector("primary"), nil, WithEnableLog(true), WithOutput(func(b []byte) (int, error) {
+		return len(b), nil
+	}))
+	defer c.Close()
+	conn, err := c.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var raw string
+	conn.Raw(func(dc interface{}) error {
+		raw = fmt.Sprintf("%T", dc)
+		return nil
+	})
+	if raw != "*fakedriver.conn" {
+		t.Fatalf("expected the connection of the driver, got %s", raw)
+	}
+}
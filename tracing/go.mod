@@ -0,0 +1,20 @@
+module github.com/go-comm/sqlxcluster/tracing
+
+go 1.20
+
+require (
+	github.com/go-comm/sqlxcluster v0.0.0
+	go.opentelemetry.io/otel v1.22.0
+	go.opentelemetry.io/otel/sdk v1.22.0
+	go.opentelemetry.io/otel/trace v1.22.0
+)
+
+require (
+	github.com/go-logr/logr v1.4.1 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/jmoiron/sqlx v1.3.5 // indirect
+	go.opentelemetry.io/otel/metric v1.22.0 // indirect
+	golang.org/x/sys v0.16.0 // indirect
+)
+
+replace github.com/go-comm/sqlxcluster => ../
@@ -0,0 +1,264 @@
+// Package tracing reports the statements and transactions of sqlxcluster as
+// OpenTelemetry spans:
+//
+//	db := sqlxcluster.OpenClusterDB(driverName, primary, replicas,
+//		sqlxcluster.WithInterceptors(tracing.NewInterceptor()))
+//
+// Statements run in a transaction begun with sqlxcluster.Begin or BeginTx are
+// children of a span that lasts until the commit or the rollback.
+package tracing
+
+import (
+	"container/list"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-comm/sqlxcluster"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/go-comm/sqlxcluster/tracing"
+
+// Attributes that have no semantic convention.
+const (
+	ClusterKey      = attribute.Key("sqlxcluster.cluster")
+	RoleKey         = attribute.Key("sqlxcluster.role")
+	TxIDKey         = attribute.Key("sqlxcluster.tx_id")
+	RowsKey         = attribute.Key("sqlxcluster.rows")
+	RowsAffectedKey = attribute.Key("sqlxcluster.rows_affected")
+	StatementsKey   = attribute.Key("sqlxcluster.statements")
+)
+
+type Option func(c *config)
+
+type config struct {
+	provider  trace.TracerProvider
+	statement bool
+}
+
+// WithTracerProvider creates the spans with provider instead of the global
+// one.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.provider = provider
+	}
+}
+
+// WithStatement sets whether the query is recorded as db.statement, which is
+// the default. Arguments are never recorded.
+func WithStatement(enable bool) Option {
+	return func(c *config) {
+		c.statement = enable
+	}
+}
+
+// NewInterceptor returns an interceptor creating a client span for every
+// statement, begin, commit and rollback.
+func NewInterceptor(opts ...Option) sqlxcluster.Interceptor {
+	c := config{statement: true}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.provider == nil {
+		c.provider = otel.GetTracerProvider()
+	}
+	return &interceptor{
+		tracer:    c.provider.Tracer(instrumentationName),
+		statement: c.statement,
+		txs:       make(map[string]*list.Element),
+		order:     list.New(),
+	}
+}
+
+// maxTxs bounds the transactions followed at once, dropped oldest first.
+const maxTxs = 10000
+
+type interceptor struct {
+	tracer    trace.Tracer
+	statement bool
+	mutex     sync.Mutex
+	txs       map[string]*list.Element // of *txSpan, by TxID
+	order     *list.List               // oldest first
+}
+
+// txSpan is the span of a transaction, open from its begin to its end.
+type txSpan struct {
+	id     string
+	span   trace.Span
+	parent trace.SpanContext // span of the context the transaction was begun with
+}
+
+// spanKey holds the span of a statement in the context.
+type spanKey struct {
+	i *interceptor
+}
+
+func (i *interceptor) addTx(tx *txSpan) {
+	i.mutex.Lock()
+	var dropped *txSpan
+	if i.order.Len() >= maxTxs {
+		dropped = i.order.Remove(i.order.Front()).(*txSpan)
+		delete(i.txs, dropped.id)
+	}
+	i.txs[tx.id] = i.order.PushBack(tx)
+	i.mutex.Unlock()
+	if dropped != nil {
+		dropped.span.End()
+	}
+}
+
+func (i *interceptor) tx(id string) *txSpan {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if el, ok := i.txs[id]; ok {
+		return el.Value.(*txSpan)
+	}
+	return nil
+}
+
+func (i *interceptor) removeTx(id string) *txSpan {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	el, ok := i.txs[id]
+	if !ok {
+		return nil
+	}
+	delete(i.txs, id)
+	return i.order.Remove(el).(*txSpan)
+}
+
+func (i *interceptor) Before(ctx context.Context, e *sqlxcluster.QueryEvent) (context.Context, error) {
+	if e.Op == sqlxcluster.OpBegin {
+		tctx, span := i.tracer.Start(ctx, "transaction", trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithTimestamp(e.Time), trace.WithAttributes(i.common(e)...))
+		i.addTx(&txSpan{id: e.TxID, span: span, parent: trace.SpanContextFromContext(ctx)})
+		ctx = tctx
+	} else if e.TxID != "" {
+		// Statements are children of their transaction unless run under a
+		// span started in it.
+		if tx := i.tx(e.TxID); tx != nil {
+			if sc := trace.SpanContextFromContext(ctx); !sc.IsValid() || sc.Equal(tx.parent) {
+				ctx = trace.ContextWithSpan(ctx, tx.span)
+			}
+		}
+	}
+	operation := operation(e)
+	attrs := append(i.common(e), semconv.DBOperation(operation))
+	if i.statement && e.Query != "" {
+		attrs = append(attrs, semconv.DBStatement(e.Query))
+	}
+	ctx, span := i.tracer.Start(ctx, operation, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(e.Time), trace.WithAttributes(attrs...))
+	return context.WithValue(ctx, spanKey{i}, span), nil
+}
+
+func (i *interceptor) After(ctx context.Context, e *sqlxcluster.QueryEvent) {
+	span, ok := ctx.Value(spanKey{i}).(trace.Span)
+	if !ok {
+		return
+	}
+	if e.RowsAffected >= 0 {
+		span.SetAttributes(RowsAffectedKey.Int64(e.RowsAffected))
+	}
+	if e.Rows >= 0 {
+		span.SetAttributes(RowsKey.Int64(e.Rows))
+	}
+	setStatus(span, e)
+	span.End(trace.WithTimestamp(e.Time.Add(e.Duration)))
+
+	switch e.Op {
+	case sqlxcluster.OpBegin:
+		if e.Err == nil {
+			return
+		}
+	case sqlxcluster.OpCommit, sqlxcluster.OpRollback:
+	default:
+		return
+	}
+	tx := i.removeTx(e.TxID)
+	if tx == nil {
+		return
+	}
+	if e.Op != sqlxcluster.OpBegin {
+		tx.span.SetAttributes(StatementsKey.Int64(e.Statements))
+	}
+	setStatus(tx.span, e)
+	tx.span.End(trace.WithTimestamp(e.Time.Add(e.Duration)))
+}
+
+// CommentTag tags the statements with the W3C traceparent of their span, for
+// sqlxcluster.WithSQLComment. The comment is appended after the interceptors
+// ran, so the span is the one of the statement when NewInterceptor is one of
+// them.
+func CommentTag() sqlxcluster.CommentTag {
+	return sqlxcluster.CommentTag{Key: "traceparent", Value: func(ctx context.Context, e *sqlxcluster.QueryEvent) string {
+		sc := trace.SpanContextFromContext(ctx)
+		if !sc.IsValid() {
+			return ""
+		}
+		return "00-" + sc.TraceID().String() + "-" + sc.SpanID().String() + "-" + sc.TraceFlags().String()
+	}}
+}
+
+func (i *interceptor) common(e *sqlxcluster.QueryEvent) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{dbSystem(e.Driver), RoleKey.String(e.Role.String())}
+	if e.Cluster != "" {
+		attrs = append(attrs, ClusterKey.String(e.Cluster))
+	}
+	if e.Node != "" {
+		host, port, err := net.SplitHostPort(e.Node)
+		if err != nil {
+			attrs = append(attrs, semconv.ServerAddress(e.Node))
+		} else if p, err := strconv.Atoi(port); err == nil {
+			attrs = append(attrs, semconv.ServerAddress(host), semconv.ServerPort(p))
+		} else {
+			attrs = append(attrs, semconv.ServerAddress(host))
+		}
+	}
+	if e.TxID != "" {
+		attrs = append(attrs, TxIDKey.String(e.TxID))
+	}
+	return attrs
+}
+
+func setStatus(span trace.Span, e *sqlxcluster.QueryEvent) {
+	if e.Failed() {
+		span.RecordError(e.Err)
+		span.SetStatus(codes.Error, e.Err.Error())
+	}
+}
+
+// operation returns the first keyword of the query, or the one of the wrapper.
+func operation(e *sqlxcluster.QueryEvent) string {
+	query := strings.TrimLeft(e.Query, " \t\r\n(")
+	if n := strings.IndexAny(query, " \t\r\n(;"); n >= 0 {
+		query = query[:n]
+	}
+	if query == "" {
+		return strings.ToUpper(string(e.Op))
+	}
+	return strings.ToUpper(query)
+}
+
+func dbSystem(driverName string) attribute.KeyValue {
+	switch {
+	case strings.Contains(driverName, "mysql"):
+		return semconv.DBSystemMySQL
+	case strings.Contains(driverName, "postgres"), driverName == "pgx":
+		return semconv.DBSystemPostgreSQL
+	case strings.Contains(driverName, "sqlite"):
+		return semconv.DBSystemSqlite
+	case driverName == "sqlserver", driverName == "mssql":
+		return semconv.DBSystemMSSQL
+	case driverName == "oracle", driverName == "godror", driverName == "oci8":
+		return semconv.DBSystemOracle
+	}
+	return semconv.DBSystemOtherSQL
+}
@@ -0,0 +1,147 @@
+package tracing
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/go-comm/sqlxcluster"
+	"github.com/go-comm/sqlxcluster/internal/fakedriver"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func attr(s sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range s.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestInterceptor(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	d := fakedriver.New()
+	db := sqlxcluster.OpenClusterDB("mysql", d.Connector("primary"), nil, sqlxcluster.WithNodeNames("db-1:3306"),
+		sqlxcluster.WithInterceptors(NewInterceptor(WithTracerProvider(provider))))
+	defer db.Close()
+
+	rows, err := db.Queryx("select 2 from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+	db.Exec("fail")
+
+	spans := exporter.GetSpans().Snapshots()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	query := spans[0]
+	if query.Name() != "SELECT" || attr(query, "db.system").AsString() != "mysql" ||
+		attr(query, "db.statement").AsString() != "select 2 from t" || attr(query, "db.operation").AsString() != "SELECT" ||
+		attr(query, "server.address").AsString() != "db-1" || attr(query, "server.port").AsInt64() != 3306 ||
+		attr(query, RoleKey).AsString() != "primary" || attr(query, RowsKey).AsInt64() != 2 {
+		t.Fatalf("unexpected query span %s %v", query.Name(), query.Attributes())
+	}
+	if spans[1].Status().Code != codes.Error || len(spans[1].Events()) != 1 {
+		t.Fatalf("expected an error status, got %v", spans[1].Status())
+	}
+
+	exporter.Reset()
+	tx, err := sqlxcluster.Begin(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Exec("update t set a = 1")
+	tx.Commit()
+
+	spans = exporter.GetSpans().Snapshots()
+	names := []string{"BEGIN", "UPDATE", "COMMIT", "transaction"}
+	if len(spans) != len(names) {
+		t.Fatalf("expected %d spans, got %d", len(names), len(spans))
+	}
+	txSpan := spans[3]
+	for i, s := range spans {
+		if s.Name() != names[i] {
+			t.Fatalf("unexpected span %d: %s", i, s.Name())
+		}
+		if i < 3 && s.Parent().SpanID() != txSpan.SpanContext().SpanID() {
+			t.Fatalf("expected %s to be a child of the transaction", s.Name())
+		}
+	}
+	if attr(txSpan, StatementsKey).AsInt64() != 1 || attr(spans[1], RowsAffectedKey).AsInt64() != 1 {
+		t.Fatalf("unexpected transaction span %v", txSpan.Attributes())
+	}
+}
+
+func TestCommentTag(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	d := fakedriver.New()
+	db := sqlxcluster.OpenClusterDB("mysql", d.Connector("primary"), nil,
+		sqlxcluster.WithInterceptors(NewInterceptor(WithTracerProvider(provider))),
+		sqlxcluster.WithSQLComment(CommentTag()))
+	defer db.Close()
+
+	db.Exec("update t set a = 1")
+	span := exporter.GetSpans().Snapshots()[0]
+	want := "update t set a = 1 /*traceparent='00-" + span.SpanContext().TraceID().String() + "-" +
+		span.SpanContext().SpanID().String() + "-01'*/"
+	if execs := d.Execs(); len(execs) != 1 || execs[0] != want {
+		t.Fatalf("unexpected statements %q, want %q", execs, want)
+	}
+	if got := attr(span, "db.statement").AsString(); got != "update t set a = 1" {
+		t.Fatalf("unexpected statement attribute %q", got)
+	}
+}
+
+func TestInnerSpan(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracer := provider.Tracer("inner")
+	d := fakedriver.New()
+	db := sqlxcluster.OpenClusterDB("mysql", d.Connector("primary"), nil,
+		sqlxcluster.WithInterceptors(NewInterceptor(WithTracerProvider(provider)), sqlxcluster.InterceptorFuncs{
+			BeforeFunc: func(ctx context.Context, e *sqlxcluster.QueryEvent) (context.Context, error) {
+				ctx, _ = tracer.Start(ctx, "inner")
+				return ctx, nil
+			},
+			AfterFunc: func(ctx context.Context, e *sqlxcluster.QueryEvent) {
+				trace.SpanFromContext(ctx).End()
+			},
+		}))
+	defer db.Close()
+
+	db.Exec("update t set a = 1")
+	spans := exporter.GetSpans().Snapshots()
+	if len(spans) != 2 || spans[0].Name() != "inner" || spans[1].Name() != "UPDATE" {
+		t.Fatalf("unexpected spans %v", spans)
+	}
+}
+
+func TestAbandonedTransactions(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	i := NewInterceptor(WithTracerProvider(provider)).(*interceptor)
+
+	ctx := context.Background()
+	for n := 0; n <= maxTxs; n++ {
+		e := &sqlxcluster.QueryEvent{Op: sqlxcluster.OpBegin, TxID: strconv.Itoa(n), Rows: -1, RowsAffected: -1}
+		ctx, _ := i.Before(ctx, e)
+		i.After(ctx, e)
+	}
+	if len(i.txs) != maxTxs || i.tx("0") != nil || i.tx("1") == nil {
+		t.Fatalf("expected the oldest transaction to be dropped, %d left", len(i.txs))
+	}
+	if spans := exporter.GetSpans().Snapshots(); len(spans) != maxTxs+2 || spans[len(spans)-2].Name() != "transaction" {
+		t.Fatalf("expected the span of the dropped transaction to end, got %d spans", len(spans))
+	}
+}